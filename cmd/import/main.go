@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/csv"
 	"fmt"
 	"log"
@@ -9,7 +8,8 @@ import (
 	"strings"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"github.com/avantifellows/link-shortener/internal/database"
+	"github.com/avantifellows/link-shortener/internal/urlpolicy"
 )
 
 type LinkRecord struct {
@@ -50,15 +50,15 @@ func main() {
 	records = records[1:]
 	
 	// Open database
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := database.OpenSQLite(dbPath)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
 
-	// Create tables if they don't exist
-	if err := createTables(db); err != nil {
-		log.Fatalf("Error creating tables: %v", err)
+	// Bring the schema up to date using the server's migrations
+	if _, err := database.Migrate(db); err != nil {
+		log.Fatalf("Error migrating database: %v", err)
 	}
 
 	// Process and import records
@@ -80,14 +80,23 @@ func main() {
 	defer checkStmt.Close()
 
 	insertStmt, err := tx.Prepare(`
-		INSERT INTO link_mappings (short_code, original_url, created_at, created_by, click_count, last_accessed)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO link_mappings (short_code, original_url, created_at, created_by, click_count, last_accessed, normalized_url)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		log.Fatalf("Error preparing insert statement: %v", err)
 	}
 	defer insertStmt.Close()
 
+	versionStmt, err := tx.Prepare(`
+		INSERT INTO link_versions (short_code, version, original_url, changed_by, created_at)
+		VALUES (?, 1, ?, ?, ?)
+	`)
+	if err != nil {
+		log.Fatalf("Error preparing version statement: %v", err)
+	}
+	defer versionStmt.Close()
+
 	for i, record := range records {
 		if len(record) < 29 { // Ensure we have enough columns
 			log.Printf("Skipping row %d: insufficient columns", i+2)
@@ -138,6 +147,7 @@ func main() {
 			"imported",
 			0,    // click_count
 			nil,  // last_accessed
+			urlpolicy.Normalize(originalURL),
 		)
 		if err != nil {
 			log.Printf("Error inserting record %d (code: %s): %v", i+2, shortCode, err)
@@ -145,6 +155,12 @@ func main() {
 			continue
 		}
 
+		// Start the link's history with its imported destination
+		_, err = versionStmt.Exec(shortCode, originalURL, "imported", createdAt.Unix())
+		if err != nil {
+			log.Fatalf("Error recording version for %s: %v", shortCode, err)
+		}
+
 		imported++
 		if imported%1000 == 0 {
 			log.Printf("Imported %d records...", imported)
@@ -188,42 +204,3 @@ func parseTimestamp(timestampStr string) (time.Time, error) {
 	
 	return time.Time{}, fmt.Errorf("unable to parse timestamp: %s", timestampStr)
 }
-
-func createTables(db *sql.DB) error {
-	createLinkMappings := `
-	CREATE TABLE IF NOT EXISTS link_mappings (
-		short_code TEXT PRIMARY KEY,
-		original_url TEXT NOT NULL,
-		created_at INTEGER NOT NULL,
-		created_by TEXT,
-		click_count INTEGER DEFAULT 0,
-		last_accessed INTEGER
-	);`
-
-	createClickAnalytics := `
-	CREATE TABLE IF NOT EXISTS click_analytics (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		short_code TEXT NOT NULL,
-		timestamp INTEGER NOT NULL,
-		user_agent TEXT,
-		ip_address TEXT,
-		referrer TEXT,
-		FOREIGN KEY (short_code) REFERENCES link_mappings(short_code)
-	);`
-
-	createIndexes := `
-	CREATE INDEX IF NOT EXISTS idx_created_at ON link_mappings(created_at);
-	CREATE INDEX IF NOT EXISTS idx_click_count ON link_mappings(click_count);
-	CREATE INDEX IF NOT EXISTS idx_short_code_timestamp ON click_analytics(short_code, timestamp);
-	`
-
-	queries := []string{createLinkMappings, createClickAnalytics, createIndexes}
-	
-	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("error executing query: %v", err)
-		}
-	}
-	
-	return nil
-}
\ No newline at end of file
@@ -0,0 +1,32 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying out and
+// testing the dashboard's single sign-on locally. It signs in whoever asks
+// as whatever email they type, so never expose it.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/avantifellows/link-shortener/internal/mockoidc"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "listen address")
+	issuer := flag.String("issuer", "", "issuer URL (default: http://ADDR)")
+	clientID := flag.String("client-id", "link-shortener", "accepted client ID")
+	clientSecret := flag.String("client-secret", "secret", "accepted client secret")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+
+	p, err := mockoidc.New(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal("Failed to start mock OIDC provider:", err)
+	}
+
+	log.Printf("Mock OIDC provider %s (client %s / %s)", *issuer, *clientID, *clientSecret)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
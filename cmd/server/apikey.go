@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+const apikeyUsage = `Usage: link-shortener apikey <command> [flags]
+
+Commands:
+  create -name NAME -scopes SCOPES [-user USERNAME]
+                   Issue a key. SCOPES is a comma-separated list of
+                   links:read, links:write, analytics:read and admin.
+                   Links created with the key belong to USERNAME; without
+                   -user, only admins can change them.
+                   The secret is printed once and cannot be shown again.
+  list             List keys
+  revoke ID        Revoke a key
+
+Flags:
+  -db string       SQLite database path (default: the configured database,
+                   see DATABASE_DRIVER)
+`
+
+// runAPIKey implements the `apikey` subcommand and returns the exit code.
+func runAPIKey(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, apikeyUsage)
+		return 2
+	}
+
+	command := args[0]
+	if command != "create" && command != "list" && command != "revoke" {
+		fmt.Fprintf(os.Stderr, "Unknown apikey command %q\n\n%s", command, apikeyUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("apikey "+command, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, apikeyUsage) }
+	dbPath := fs.String("db", "", "SQLite database path")
+	name := fs.String("name", "", "name identifying the key's owner")
+	scopes := fs.String("scopes", "", "comma-separated scopes")
+	username := fs.String("user", "", "user the key acts for")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	var revokeID int64
+	if command == "revoke" {
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if fs.NArg() != 1 || err != nil {
+			fmt.Fprintf(os.Stderr, "apikey revoke needs a numeric key ID\n\n%s", apikeyUsage)
+			return 2
+		}
+		revokeID = id
+	}
+
+	db, err := openDatabase(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	if pending, err := database.Pending(db); err != nil || len(pending) > 0 {
+		fmt.Fprintln(os.Stderr, "Error: the database schema is not up to date, run `link-shortener migrate up` first")
+		return 1
+	}
+
+	store := storage.NewSQLStore(db)
+	keys := services.NewAPIKeyService(store)
+
+	switch command {
+	case "create":
+		var user *models.User
+		if *username != "" {
+			if user, err = services.NewUserService(store).GetUser(*username); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to create API key: %v\n", err)
+				return 1
+			}
+		}
+		key, secret, err := keys.CreateKey(*name, strings.Split(*scopes, ","), user)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create API key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Created API key %d (%s) with scopes %s\n\n", key.ID, key.Name, strings.Join(key.Scopes, ", "))
+		fmt.Printf("  %s\n\n", secret)
+		fmt.Println("Store this secret now; it cannot be shown again.")
+
+	case "list":
+		list, err := keys.ListKeys()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list API keys: %v\n", err)
+			return 1
+		}
+		for _, key := range list {
+			fmt.Printf("%4d  %-24s %-14s %-16s %-40s %s\n", key.ID, key.Name, key.Prefix+"…", keyUser(key), strings.Join(key.Scopes, ","), keyState(key))
+		}
+		fmt.Printf("\n%d key(s)\n", len(list))
+
+	case "revoke":
+		if err := keys.RevokeKey(revokeID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke API key %d: %v\n", revokeID, err)
+			return 1
+		}
+		fmt.Printf("Revoked API key %d\n", revokeID)
+	}
+
+	return 0
+}
+
+// keyState summarises when a key was last used or revoked.
+func keyState(key models.APIKey) string {
+	switch {
+	case key.RevokedAt != nil:
+		return "revoked " + key.RevokedAt.Format("2006-01-02 15:04")
+	case key.LastUsedAt != nil:
+		return "last used " + key.LastUsedAt.Format("2006-01-02 15:04")
+	default:
+		return "never used"
+	}
+}
+
+// keyUser names the user a key acts for.
+func keyUser(key models.APIKey) string {
+	if key.Username == "" {
+		return "-"
+	}
+	return key.Username
+}
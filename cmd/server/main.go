@@ -9,9 +9,16 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/avantifellows/link-shortener/internal/blocklist"
+	"github.com/avantifellows/link-shortener/internal/cache"
 	"github.com/avantifellows/link-shortener/internal/database"
 	"github.com/avantifellows/link-shortener/internal/handlers"
+	"github.com/avantifellows/link-shortener/internal/journal"
 	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/avantifellows/link-shortener/internal/shortcode"
+	"github.com/avantifellows/link-shortener/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
@@ -23,6 +30,18 @@ func main() {
 		log.Printf("No .env.local file found, using environment variables: %v", err)
 	}
 
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "apikey":
+			os.Exit(runAPIKey(os.Args[2:]))
+		case "user":
+			os.Exit(runUser(os.Args[2:]))
+		}
+	}
+
 	// Initialize database
 	db, err := database.Initialize()
 	if err != nil {
@@ -30,29 +49,179 @@ func main() {
 	}
 	defer db.Close()
 
-	// Initialize handlers
-	h := handlers.New(db)
+	// Open the click journal that buffers analytics on disk. Instances
+	// sharing a PostgreSQL database need a journal each, so there is no
+	// default directory to share by accident.
+	if db.Dialect == database.Postgres && os.Getenv("CLICK_JOURNAL_DIR") == "" {
+		log.Fatal("CLICK_JOURNAL_DIR must be set when using PostgreSQL")
+	}
+	clicks, err := journal.Open(journal.ConfigFromEnv())
+	if err != nil {
+		log.Fatal("Failed to open click journal:", err)
+	}
+
+	// Cache redirect lookups in memory, backed by Redis when configured
+	cacheCfg := cache.ConfigFromEnv()
+	redisCfg := cache.RedisConfigFromEnv()
+	if redisCfg.URL != "" {
+		cacheCfg = cacheCfg.InFrontOfRedis(redisCfg.LocalTTL)
+	}
+	local := cache.NewLRU(cacheCfg)
+	var linkCache cache.Cache = local
+	if redisCfg.URL != "" {
+		remote, err := cache.OpenRedis(redisCfg)
+		if err != nil {
+			log.Fatal("Failed to configure Redis cache:", err)
+		}
+		defer remote.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := remote.Ping(ctx); err != nil {
+			log.Printf("Redis cache unreachable, lookups will fall back to the database: %v", err)
+		}
+		cancel()
+
+		linkCache = cache.NewTiered(local, remote)
+	}
+
+	// Single sign-on for the dashboard, when an OIDC provider is configured
+	var oidcService *services.OIDCService
+	if oidcCfg := services.OIDCConfigFromEnv(); oidcCfg.IssuerURL != "" {
+		oidcService, err = services.NewOIDCService(oidcCfg)
+		if err != nil {
+			log.Fatal("Failed to configure OIDC:", err)
+		}
+	}
+
+	// Phishing and malware lists that destinations are checked against
+	blocked, err := blocklist.New(blocklist.ConfigFromEnv())
+	if err != nil {
+		log.Fatal("Failed to load blocklist:", err)
+	}
+
+	// Short codes that would shadow routes or give offence, and how codes
+	// are generated for links without a custom one
+	reserved, err := shortcode.ReservedFromEnv()
+	if err != nil {
+		log.Fatal("Failed to load reserved short codes:", err)
+	}
+	codeCfg := shortcode.GeneratorConfigFromEnv()
+	codes, err := shortcode.NewRandomGenerator(codeCfg, reserved)
+	if err != nil {
+		log.Fatal("Failed to configure short code generator:", err)
+	}
 
-	// Setup router
+	// Start from the code length earlier runs grew to, which is only kept
+	// in memory, by looking at how full each length already is
+	store := storage.NewSQLStore(db)
+	counts, err := store.CountLinksByCodeLength()
+	if err != nil {
+		log.Fatal("Failed to count links by code length:", err)
+	}
+	if length := codes.Resume(counts); length != codeCfg.Length {
+		log.Printf("Generating %d-character short codes, since shorter ones are filling up", length)
+	}
+
+	// Initialize handlers and start background click processing and
+	// blocklist rechecks
+	h := handlers.New(store, linkCache, clicks, oidcService, blocked, reserved, codes)
+	h.Start(context.Background())
+
+	// Forwarding headers are only believed from trusted proxies
+	trustedProxies, err := authmiddleware.TrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatal("Failed to read trusted proxies:", err)
+	}
+
+	// Setup router. The first path segment of every route must also be
+	// reserved in the shortcode package, so that no link can shadow it.
 	r := chi.NewRouter()
 
 	// Middleware
+	r.Use(authmiddleware.NewIPResolver(trustedProxies).Resolve)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	// API keys (and the legacy AUTH_TOKEN) or dashboard sessions
+	// authenticate protected routes. Each group then requires a minimum
+	// role as well as the scopes an API key must have.
+	auth := authmiddleware.NewAuthenticator(services.NewAPIKeyService(store), services.NewUserService(store))
+	requireScope := authmiddleware.RequireScope
+	requireRole := authmiddleware.RequireRole
+
+	// Token buckets per API key or user for link creation, and per client
+	// IP for redirects
+	createLimit := authmiddleware.NewRateLimiter(authmiddleware.RateLimitConfigFromEnv("SHORTEN", 60, 20)).Limit(authmiddleware.ByPrincipal)
+	redirectLimit := authmiddleware.NewRateLimiter(authmiddleware.RateLimitConfigFromEnv("REDIRECT", 600, 200)).Limit(authmiddleware.ByClientIP)
+
 	// Public routes (no authentication required)
 	r.Get("/health", h.Health)
-	r.Get("/", h.Dashboard)         // Dashboard public for now
-	r.Get("/analytics", h.Analytics) // Analytics public for now
-	r.Get("/{code}", h.RedirectURL) // Redirects should be public - MUST be last to avoid conflicts
+	r.Get("/login", h.LoginPage)
+	r.Post("/login", h.Login)
+	r.Get("/auth/oidc/login", h.OIDCLogin)
+	r.Get(services.OIDCCallbackPath, h.OIDCCallback)
+	r.With(redirectLimit).Get("/{code}", h.RedirectURL) // Redirects should be public - MUST be last to avoid conflicts
 
-	// Protected routes (require authentication)
+	// Dashboard pages (require a login session)
 	r.Group(func(r chi.Router) {
-		r.Use(authmiddleware.AuthMiddleware)
-		r.Post("/shorten", h.CreateShortURL) // All link creation requires auth
+		r.Use(auth.RequireLogin)
+		r.Get("/", h.Dashboard)
+		r.Post("/logout", h.Logout)
+	})
+
+	// Analytics, including click-level IP addresses and user agents
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AuthMiddleware, requireRole(models.RoleViewer), requireScope(models.ScopeAnalyticsRead))
+		r.Get("/analytics", h.Analytics)
+	})
+
+	// Link creation from the dashboard and scripts
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AuthMiddleware, requireRole(models.RoleCreator), requireScope(models.ScopeLinksWrite))
+		r.With(createLimit, h.Idempotent).Post("/shorten", h.CreateShortURL)
+	})
+
+	// REST API for managing links
+	r.Route("/api/v1/links", func(r chi.Router) {
+		r.Use(auth.AuthMiddleware)
+		r.Group(func(r chi.Router) {
+			r.Use(requireRole(models.RoleViewer), requireScope(models.ScopeLinksRead))
+			r.Get("/", h.ListLinks)
+			r.Get("/{code}", h.GetLink)
+			r.Get("/{code}/versions", h.LinkVersions)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(requireRole(models.RoleCreator), requireScope(models.ScopeLinksWrite))
+			r.With(createLimit, h.Idempotent).Post("/", h.CreateLink)
+			r.With(createLimit, h.Idempotent).Post("/batch", h.CreateLinks)
+			r.Patch("/{code}", h.UpdateLink)
+			r.Delete("/{code}", h.DeleteLink)
+			r.Post("/{code}/rollback", h.RollbackLink)
+			r.Post("/{code}/restore", h.RestoreLink)
+			r.Post("/{code}/disable", h.DisableLink)
+			r.Post("/{code}/enable", h.EnableLink)
+		})
+	})
+
+	// Administration: API keys, users, the blocklist audit trail and
+	// operational counters
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AuthMiddleware, requireRole(models.RoleAdmin), requireScope(models.ScopeAdmin))
+		r.Route("/api/v1/keys", func(r chi.Router) {
+			r.Get("/", h.ListAPIKeys)
+			r.Post("/", h.CreateAPIKey)
+			r.Delete("/{id}", h.RevokeAPIKey)
+		})
+		r.Route("/api/v1/users", func(r chi.Router) {
+			r.Get("/", h.ListUsers)
+			r.Post("/", h.CreateUser)
+			r.Patch("/{username}", h.UpdateUser)
+			r.Delete("/{username}", h.DeleteUser)
+		})
+		r.Get("/api/v1/blocklist/events", h.ListBlocklistEvents)
+		r.Get("/api/v1/stats", h.Stats)
 	})
 
 	// Serve static files
@@ -90,7 +259,12 @@ func main() {
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+
+	// Apply journaled clicks while the database is still open
+	if err := h.Stop(ctx); err != nil {
+		log.Printf("Failed to flush click journal: %v", err)
 	}
 
 	log.Println("Server exited")
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+)
+
+const migrateUsage = `Usage: link-shortener migrate <command> [flags]
+
+Commands:
+  status           List migrations and whether they have been applied
+  up [-dry-run]    Apply pending migrations (-dry-run applies them in a
+                   transaction that is rolled back)
+
+Flags:
+  -db string       SQLite database path (default: the configured database,
+                   see DATABASE_DRIVER)
+`
+
+// runMigrate implements the `migrate` subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	command := args[0]
+	if command != "status" && command != "up" {
+		fmt.Fprintf(os.Stderr, "Unknown migrate command %q\n\n%s", command, migrateUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, migrateUsage) }
+	dbPath := fs.String("db", "", "SQLite database path")
+	dryRun := fs.Bool("dry-run", false, "apply pending migrations in a rolled-back transaction")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := openDatabase(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch command {
+	case "status":
+		statuses, err := database.Status(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read migration status: %v\n", err)
+			return 1
+		}
+
+		pending := 0
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			} else {
+				pending++
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, state)
+		}
+		fmt.Printf("\n%d migration(s), %d pending\n", len(statuses), pending)
+
+	case "up":
+		if *dryRun {
+			pending, err := database.DryRun(db)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Dry run failed: %v\n", err)
+				return 1
+			}
+			for _, m := range pending {
+				fmt.Printf("would apply %04d  %s\n", m.Version, m.Name)
+			}
+			fmt.Printf("\nDry run OK: %d migration(s) pending, nothing was changed\n", len(pending))
+			return 0
+		}
+
+		applied, err := database.Migrate(db)
+		for _, m := range applied {
+			fmt.Printf("applied %04d  %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("\n%d migration(s) applied\n", len(applied))
+	}
+
+	return 0
+}
+
+// openDatabase opens the SQLite file at dbPath, or the configured database
+// when dbPath is empty, for the command-line subcommands.
+func openDatabase(dbPath string) (*database.DB, error) {
+	cfg := database.ConfigFromEnv()
+	if dbPath != "" {
+		cfg = database.Config{Driver: database.SQLite, Path: dbPath}
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", cfg.Driver, err)
+	}
+	return db, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/avantifellows/link-shortener/internal/storage"
+	"golang.org/x/term"
+)
+
+const userUsage = `Usage: link-shortener user <command> [flags]
+
+Commands:
+  create [-role ROLE] USERNAME
+                   Add a dashboard user
+  role USERNAME ROLE
+                   Change a user's role
+  password USERNAME
+                   Change a user's password and sign out their sessions
+  list             List users
+  delete USERNAME  Remove a user
+
+Roles:
+  viewer           See links and analytics, including click details
+  creator          Also create links and change or delete their own
+  admin            Also change every link and manage users and API keys
+
+Passwords are prompted for on a terminal, or read from the first line of
+standard input otherwise.
+
+Flags:
+  -db string       SQLite database path (default: the configured database,
+                   see DATABASE_DRIVER)
+  -role string     Role for create (default "viewer")
+`
+
+// runUser implements the `user` subcommand and returns the exit code.
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+
+	command := args[0]
+	switch command {
+	case "create", "role", "password", "list", "delete":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown user command %q\n\n%s", command, userUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("user "+command, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, userUsage) }
+	dbPath := fs.String("db", "", "SQLite database path")
+	roleName := fs.String("role", string(models.RoleViewer), "role for create")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	username := fs.Arg(0)
+	wantArgs, argNames := 1, "a username"
+	if command == "role" {
+		*roleName = fs.Arg(1)
+		wantArgs, argNames = 2, "a username and a role"
+	}
+	if command != "list" && (fs.NArg() != wantArgs || username == "") {
+		fmt.Fprintf(os.Stderr, "user %s needs %s\n\n%s", command, argNames, userUsage)
+		return 2
+	}
+
+	role, ok := models.ParseRole(*roleName)
+	if !ok && (command == "create" || command == "role") {
+		fmt.Fprintf(os.Stderr, "Unknown role %q\n\n%s", *roleName, userUsage)
+		return 2
+	}
+
+	db, err := openDatabase(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	if pending, err := database.Pending(db); err != nil || len(pending) > 0 {
+		fmt.Fprintln(os.Stderr, "Error: the database schema is not up to date, run `link-shortener migrate up` first")
+		return 1
+	}
+
+	users := services.NewUserService(storage.NewSQLStore(db))
+
+	switch command {
+	case "create":
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		user, err := users.CreateUser(username, password, role)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create user: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Created %s %s\n", user.Role, user.Username)
+
+	case "role":
+		user, err := users.SetRole(username, role)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to change role: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s is now a %s\n", user.Username, user.Role)
+
+	case "password":
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := users.SetPassword(username, password); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to change password: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Changed password for %s\n", username)
+
+	case "list":
+		list, err := users.ListUsers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list users: %v\n", err)
+			return 1
+		}
+		for _, user := range list {
+			fmt.Printf("%4d  %-40s %-7s created %s\n", user.ID, user.Username, user.Role, user.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("\n%d user(s)\n", len(list))
+
+	case "delete":
+		if err := users.DeleteUser(username); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete user: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Deleted user %s\n", username)
+	}
+
+	return 0
+}
+
+// readPassword prompts twice for a password on a terminal, or reads one
+// line from standard input when it is not a terminal.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password from standard input: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	repeat, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(repeat) {
+		return "", errors.New("passwords do not match")
+	}
+
+	return string(password), nil
+}
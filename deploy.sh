@@ -28,7 +28,7 @@ echo "🚀 Deploying Link Shortener to server..."
 
 # Build binary for Linux
 echo "🔨 Building binary for Linux..."
-GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o link-shortener-linux cmd/server/main.go
+GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o link-shortener-linux ./cmd/server
 
 # Create deployment directory
 ssh -i $KEY_PATH $SERVER "sudo mkdir -p $DEPLOY_DIR"
@@ -0,0 +1,320 @@
+// Package blocklist matches destination URLs against local phishing and
+// malware lists, such as URLhaus dumps, hosts files or Safe Browsing style
+// hash prefix lists.
+package blocklist
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config lists the blocklist files and how often links are rechecked
+// against them.
+type Config struct {
+	Files []string
+	// RecheckInterval is how often the files are reloaded and every
+	// active link is checked again.
+	RecheckInterval time.Duration
+}
+
+// ConfigFromEnv reads the comma-separated BLOCKLIST_FILES and
+// BLOCKLIST_RECHECK_INTERVAL (default 6h).
+func ConfigFromEnv() Config {
+	cfg := Config{RecheckInterval: 6 * time.Hour}
+	for _, file := range strings.Split(os.Getenv("BLOCKLIST_FILES"), ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			cfg.Files = append(cfg.Files, file)
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("BLOCKLIST_RECHECK_INTERVAL")); err == nil && d > 0 {
+		cfg.RecheckInterval = d
+	}
+	return cfg
+}
+
+// Match describes the blocklist entry a URL matched.
+type Match struct {
+	// Source is the name of the file the entry came from.
+	Source string
+	Entry  string
+}
+
+// list is one loaded set of entries, replaced as a whole on reload.
+type list struct {
+	// domains match the domain and its subdomains.
+	domains map[string]string
+	// expressions are host and path expressions such as
+	// "evil.example/login", see expressions.
+	expressions map[string]string
+	// hashes are hex SHA-256 prefixes of expressions, keyed by their
+	// length in hex digits.
+	hashes map[int]map[string]string
+	size   int
+}
+
+// Blocklist is the set of entries loaded from the configured files. It is
+// safe for concurrent use.
+type Blocklist struct {
+	cfg  Config
+	list atomic.Pointer[list]
+}
+
+// New loads the configured files. With no files the blocklist matches
+// nothing.
+func New(cfg Config) (*Blocklist, error) {
+	b := &Blocklist{cfg: cfg}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Enabled reports whether any blocklist files are configured.
+func (b *Blocklist) Enabled() bool {
+	return len(b.cfg.Files) > 0
+}
+
+func (b *Blocklist) RecheckInterval() time.Duration {
+	return b.cfg.RecheckInterval
+}
+
+// Size returns the number of entries loaded.
+func (b *Blocklist) Size() int {
+	return b.list.Load().size
+}
+
+// Reload reads the files again. If any of them cannot be read the
+// previously loaded entries are kept.
+func (b *Blocklist) Reload() error {
+	l := &list{
+		domains:     make(map[string]string),
+		expressions: make(map[string]string),
+		hashes:      make(map[int]map[string]string),
+	}
+	for _, path := range b.cfg.Files {
+		if err := l.load(path); err != nil {
+			return err
+		}
+	}
+	b.list.Store(l)
+	return nil
+}
+
+// Match returns the entry rawURL matches, or nil if it is not listed.
+func (b *Blocklist) Match(rawURL string) *Match {
+	l := b.list.Load()
+	if l.size == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := canonicalHost(u.Hostname())
+	if host == "" {
+		return nil
+	}
+
+	for domain := host; domain != ""; {
+		if source, ok := l.domains[domain]; ok {
+			return &Match{Source: source, Entry: domain}
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+
+	for _, expr := range expressions(host, u) {
+		if source, ok := l.expressions[expr]; ok {
+			return &Match{Source: source, Entry: expr}
+		}
+		if len(l.hashes) == 0 {
+			continue
+		}
+		sum := sha256.Sum256([]byte(expr))
+		digest := hex.EncodeToString(sum[:])
+		for n, prefixes := range l.hashes {
+			if source, ok := prefixes[digest[:n]]; ok {
+				return &Match{Source: source, Entry: digest[:n]}
+			}
+		}
+	}
+
+	return nil
+}
+
+// load adds the entries in one file. Each line is one of:
+//
+//	evil.example                 a domain and its subdomains
+//	0.0.0.0 evil.example         a hosts file entry
+//	||evil.example^              an adblock domain rule
+//	http://evil.example/login    a URL, matched without its scheme
+//	"1","...","http://evil..."   a URLhaus CSV row, using its URL field
+//	9f86d081884c7d65             a hex SHA-256 prefix (4 to 32 bytes) of a
+//	                             host and path expression
+//
+// Blank lines and lines starting with # are skipped.
+func (l *list) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("blocklist: %w", err)
+	}
+	defer file.Close()
+
+	source := filepath.Base(path)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		l.add(line, source)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("blocklist: %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (l *list) add(line, source string) {
+	if line[0] == '"' {
+		fields, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			return
+		}
+		for _, field := range fields {
+			if strings.Contains(field, "://") {
+				l.addURL(field, source)
+				return
+			}
+		}
+		return
+	}
+
+	if strings.Contains(line, "://") {
+		l.addURL(line, source)
+		return
+	}
+
+	if isHashPrefix(line) {
+		prefix := strings.ToLower(line)
+		if l.hashes[len(prefix)] == nil {
+			l.hashes[len(prefix)] = make(map[string]string)
+		}
+		l.hashes[len(prefix)][prefix] = source
+		l.size++
+		return
+	}
+
+	// Hosts files list an address and then the blocked names
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			fields = fields[1:]
+		}
+	}
+	for _, field := range fields {
+		field = strings.TrimSuffix(strings.TrimPrefix(field, "||"), "^")
+		domain := canonicalHost(field)
+		// Skips localhost and the like, which hosts files also list
+		if strings.Contains(domain, ".") {
+			l.domains[domain] = source
+			l.size++
+		}
+	}
+}
+
+func (l *list) addURL(rawURL, source string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	host := canonicalHost(u.Hostname())
+	if host == "" {
+		return
+	}
+	l.expressions[expressions(host, u)[0]] = source
+	l.size++
+}
+
+// isHashPrefix reports whether s is 8 to 64 hex digits; domains always
+// contain a dot.
+func isHashPrefix(s string) bool {
+	if len(s) < 8 || len(s) > 64 || len(s)%2 != 0 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// canonicalHost lowercases host and removes stray dots.
+func canonicalHost(host string) string {
+	host = strings.Trim(strings.ToLower(host), ".")
+	for strings.Contains(host, "..") {
+		host = strings.ReplaceAll(host, "..", ".")
+	}
+	return host
+}
+
+// expressions returns the host and path combinations a URL is looked up
+// under, most specific first, as Safe Browsing does: the host and up to
+// four parent domains, each with the full path and query, the path alone
+// and up to four leading path prefixes.
+func expressions(host string, u *url.URL) []string {
+	hosts := []string{host}
+	if _, err := netip.ParseAddr(host); err != nil {
+		labels := strings.Split(host, ".")
+		if len(labels) > 5 {
+			labels = labels[len(labels)-5:]
+		}
+		for i := 1; i < len(labels)-1; i++ {
+			if suffix := strings.Join(labels[i:], "."); suffix != host {
+				hosts = append(hosts, suffix)
+			}
+		}
+	}
+
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	var paths []string
+	if u.RawQuery != "" {
+		paths = append(paths, p+"?"+u.RawQuery)
+	}
+	paths = append(paths, p)
+	prefix := "/"
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	for i := 0; i < len(segments) && len(paths) < 6; i++ {
+		if prefix != p {
+			paths = append(paths, prefix)
+		}
+		if segments[i] == "" {
+			break
+		}
+		prefix += segments[i] + "/"
+	}
+
+	var exprs []string
+	for _, h := range hosts {
+		for _, path := range paths {
+			exprs = append(exprs, h+path)
+		}
+	}
+	return exprs
+}
@@ -0,0 +1,23 @@
+package cache
+
+import "github.com/avantifellows/link-shortener/internal/models"
+
+// Cache is a lookup cache for links keyed by short code. Implementations
+// never fail: a cache that cannot be reached behaves as if it were empty,
+// so callers fall back to the database.
+type Cache interface {
+	// Get looks up a short code. found reports whether the code was cached
+	// at all; when it is true and link is nil, the code is known not to
+	// exist. The returned link must not be modified.
+	Get(code string) (link *models.LinkMapping, found bool)
+	// Set caches a link under its short code after it was written.
+	Set(link *models.LinkMapping)
+	// Fill caches a link just read from the database. The read may have
+	// raced an update, so shared tiers keep fills only briefly.
+	Fill(link *models.LinkMapping)
+	// SetMissing records that a short code does not exist.
+	SetMissing(code string)
+	// Invalidate drops any cached entry for a short code.
+	Invalidate(code string)
+	Stats() Stats
+}
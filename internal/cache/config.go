@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultMaxBytes = 32 << 20
+
+// Config bounds the cache and sets how long entries stay valid.
+type Config struct {
+	// MaxBytes is the approximate memory budget; zero disables the cache.
+	MaxBytes int64
+	// TTL expires cached links so that edits made by other instances are
+	// picked up. Zero keeps entries until they are evicted or invalidated.
+	// In front of Redis it is capped by RedisConfig.LocalTTL.
+	TTL time.Duration
+	// NegativeTTL is how long an unknown short code is remembered; zero
+	// disables negative caching.
+	NegativeTTL time.Duration
+}
+
+// ConfigFromEnv reads the cache configuration from LINK_CACHE_*
+// environment variables.
+func ConfigFromEnv() Config {
+	return Config{
+		MaxBytes:    envInt64("LINK_CACHE_MAX_BYTES", defaultMaxBytes),
+		TTL:         envDuration("LINK_CACHE_TTL", 10*time.Minute),
+		NegativeTTL: envDuration("LINK_CACHE_NEGATIVE_TTL", 30*time.Second),
+	}
+}
+
+// InFrontOfRedis returns the configuration for an in-memory tier in front
+// of Redis. Other instances only invalidate Redis, so entries are kept for
+// at most maxTTL; zero disables the in-memory tier.
+func (c Config) InFrontOfRedis(maxTTL time.Duration) Config {
+	if maxTTL <= 0 {
+		c.MaxBytes = 0
+		return c
+	}
+	if c.TTL == 0 || c.TTL > maxTTL {
+		c.TTL = maxTTL
+	}
+	if c.NegativeTTL > maxTTL {
+		c.NegativeTTL = maxTTL
+	}
+	return c
+}
+
+// envInt64 and envDuration accept zero so that operators can turn the
+// cache or expiry off explicitly.
+func envInt64(name string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,174 @@
+// Package cache keeps recently resolved short links in memory so that
+// redirects for popular links do not hit the database.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// entryOverhead approximates the memory used by an entry beyond its
+// strings: the list element, map bucket and LinkMapping struct.
+const entryOverhead = 256
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Entries      int    `json:"entries"`
+	Bytes        int64  `json:"bytes"`
+	MaxBytes     int64  `json:"max_bytes"`
+
+	// Redis is set when a Redis tier sits behind the in-memory cache.
+	Redis *RedisStats `json:"redis,omitempty"`
+}
+
+type entry struct {
+	code    string
+	link    *models.LinkMapping // nil for a cached "not found"
+	size    int64
+	expires time.Time
+}
+
+// LRU is a size-bounded, least recently used cache of links keyed by short
+// code. Unknown codes can be cached as misses so that repeated requests for
+// them do not reach the database either. It is safe for concurrent use.
+type LRU struct {
+	cfg Config
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+}
+
+// NewLRU creates an empty cache. A MaxBytes of zero disables caching.
+func NewLRU(cfg Config) *LRU {
+	return &LRU{
+		cfg:   cfg,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get looks up a short code. found reports whether the code was cached at
+// all; when it is true and link is nil, the code is known not to exist.
+// The returned link must not be modified.
+func (c *LRU) Get(code string) (link *models.LinkMapping, found bool) {
+	c.mu.Lock()
+	el, ok := c.items[code]
+	if ok {
+		e := el.Value.(*entry)
+		if !e.expires.IsZero() && time.Now().After(e.expires) {
+			c.remove(el)
+			ok = false
+		} else {
+			c.ll.MoveToFront(el)
+			link = e.link
+		}
+	}
+	c.mu.Unlock()
+
+	switch {
+	case !ok:
+		c.misses.Add(1)
+	case link == nil:
+		c.negativeHits.Add(1)
+	default:
+		c.hits.Add(1)
+	}
+	return link, ok
+}
+
+// Set caches a link under its short code.
+func (c *LRU) Set(link *models.LinkMapping) {
+	stored := *link
+	size := int64(entryOverhead + 2*len(link.ShortCode) + len(link.OriginalURL) + len(link.CreatedBy) + len(link.FallbackURL))
+	c.add(link.ShortCode, &stored, size, c.cfg.TTL)
+}
+
+// Fill is Set: an in-memory cache only holds this instance's reads, which
+// its own writes invalidate, and expires them after TTL regardless.
+func (c *LRU) Fill(link *models.LinkMapping) {
+	c.Set(link)
+}
+
+// SetMissing records that a short code does not exist. It is a no-op when
+// NegativeTTL is zero.
+func (c *LRU) SetMissing(code string) {
+	if c.cfg.NegativeTTL <= 0 {
+		return
+	}
+	c.add(code, nil, int64(entryOverhead+2*len(code)), c.cfg.NegativeTTL)
+}
+
+// Invalidate drops any cached entry for a short code.
+func (c *LRU) Invalidate(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[code]; ok {
+		c.remove(el)
+	}
+}
+
+// Stats returns the current counters.
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	entries, bytes := c.ll.Len(), c.bytes
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Entries:      entries,
+		Bytes:        bytes,
+		MaxBytes:     c.cfg.MaxBytes,
+	}
+}
+
+func (c *LRU) add(code string, link *models.LinkMapping, size int64, ttl time.Duration) {
+	if size > c.cfg.MaxBytes {
+		return
+	}
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[code]; ok {
+		c.remove(el)
+	}
+
+	c.items[code] = c.ll.PushFront(&entry{code: code, link: link, size: size, expires: expires})
+	c.bytes += size
+
+	for c.bytes > c.cfg.MaxBytes {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove unlinks an element; the caller must hold c.mu.
+func (c *LRU) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.code)
+	c.bytes -= e.size
+}
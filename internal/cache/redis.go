@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig describes the optional Redis tier.
+type RedisConfig struct {
+	// URL is a redis:// or rediss:// URL; empty disables the tier.
+	URL       string
+	KeyPrefix string
+	// TTL is set on every cached link so that Redis can evict them under
+	// both allkeys-lru and volatile-lru policies, and so that edits made
+	// outside this service eventually show up.
+	TTL time.Duration
+	// FillTTL is set instead on links cached after a database read. A read
+	// that raced an update can cache the old link after the update was
+	// invalidated, and would otherwise serve it for TTL. Zero leaves reads
+	// out of Redis, so that only writes populate it.
+	FillTTL     time.Duration
+	NegativeTTL time.Duration
+	// LocalTTL caps how long each instance keeps links in memory in front
+	// of Redis, and so how long it can serve a link that another instance
+	// changed or disabled. Zero skips the in-memory tier.
+	LocalTTL time.Duration
+	// Timeout bounds each Redis command so that a slow Redis cannot stall
+	// redirects.
+	Timeout time.Duration
+	// RetryInterval is how long Redis is bypassed after a failure.
+	RetryInterval time.Duration
+}
+
+// RedisConfigFromEnv reads the Redis tier configuration from REDIS_*
+// environment variables.
+func RedisConfigFromEnv() RedisConfig {
+	prefix := os.Getenv("REDIS_KEY_PREFIX")
+	if prefix == "" {
+		prefix = "link-shortener:link:"
+	}
+
+	return RedisConfig{
+		URL:           os.Getenv("REDIS_URL"),
+		KeyPrefix:     prefix,
+		TTL:           envDuration("REDIS_CACHE_TTL", 24*time.Hour),
+		FillTTL:       envDuration("REDIS_CACHE_FILL_TTL", time.Minute),
+		NegativeTTL:   envDuration("REDIS_CACHE_NEGATIVE_TTL", 30*time.Second),
+		LocalTTL:      envDuration("REDIS_LOCAL_TTL", 5*time.Second),
+		Timeout:       envDuration("REDIS_TIMEOUT", 100*time.Millisecond),
+		RetryInterval: envDuration("REDIS_RETRY_INTERVAL", 5*time.Second),
+	}
+}
+
+// redisEntry is the JSON value stored per short code. A null Link records
+// that the code does not exist.
+type redisEntry struct {
+	Link *models.LinkMapping `json:"link"`
+}
+
+// Redis stores links in Redis. Unlike the in-memory cache its methods
+// return errors; Tiered decides what to do when Redis is unavailable.
+type Redis struct {
+	client redis.UniversalClient
+	cfg    RedisConfig
+}
+
+// NewRedis wraps an existing client, such as one pointed at a local
+// redis-server or an in-memory stand-in.
+func NewRedis(client redis.UniversalClient, cfg RedisConfig) *Redis {
+	return &Redis{client: client, cfg: cfg}
+}
+
+// OpenRedis connects to cfg.URL. The connection is established lazily, so
+// an unreachable Redis is not an error here.
+func OpenRedis(cfg RedisConfig) (*Redis, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	opts.DialTimeout = cfg.Timeout
+	opts.ReadTimeout = cfg.Timeout
+	opts.WriteTimeout = cfg.Timeout
+
+	return NewRedis(redis.NewClient(opts), cfg), nil
+}
+
+// Ping checks that Redis is reachable.
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Get looks up a short code; see Cache.Get for the meaning of the results.
+func (r *Redis) Get(ctx context.Context, code string) (*models.LinkMapping, bool, error) {
+	data, err := r.client.Get(ctx, r.key(code)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var e redisEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached link %s: %w", code, err)
+	}
+	return e.Link, true, nil
+}
+
+// Set stores a link under its short code.
+func (r *Redis) Set(ctx context.Context, link *models.LinkMapping) error {
+	return r.put(ctx, link.ShortCode, link, r.cfg.TTL)
+}
+
+// Fill stores a link read from the database, for FillTTL.
+func (r *Redis) Fill(ctx context.Context, link *models.LinkMapping) error {
+	if r.cfg.FillTTL <= 0 {
+		return nil
+	}
+	return r.put(ctx, link.ShortCode, link, r.cfg.FillTTL)
+}
+
+// SetMissing records that a short code does not exist.
+func (r *Redis) SetMissing(ctx context.Context, code string) error {
+	if r.cfg.NegativeTTL <= 0 {
+		return nil
+	}
+	return r.put(ctx, code, nil, r.cfg.NegativeTTL)
+}
+
+// Delete removes any entry for a short code.
+func (r *Redis) Delete(ctx context.Context, code string) error {
+	return r.client.Del(ctx, r.key(code)).Err()
+}
+
+// Close releases the client's connections.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+func (r *Redis) put(ctx context.Context, code string, link *models.LinkMapping, ttl time.Duration) error {
+	data, err := json.Marshal(redisEntry{Link: link})
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(code), data, jitter(ttl)).Err()
+}
+
+func (r *Redis) key(code string) string {
+	return r.cfg.KeyPrefix + code
+}
+
+// jitter spreads expiry over an extra 10% of ttl so that links cached
+// together, such as those shared in one session, do not all expire at once.
+func jitter(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return 0
+	}
+	return ttl + rand.N(ttl/10+1)
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// RedisStats counts lookups that reached the Redis tier.
+type RedisStats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Misses       uint64 `json:"misses"`
+	Errors       uint64 `json:"errors"`
+	// Available is false while Redis is being bypassed after a failure.
+	Available bool `json:"available"`
+}
+
+// Tiered puts the in-memory LRU in front of Redis. Writes go through to
+// both tiers, so links created or edited on one instance are served from
+// Redis by the others. Invalidation only reaches this instance's LRU and
+// Redis, so other instances may serve a changed or disabled link from
+// memory until their entry expires; the LRU should be configured with
+// Config.InFrontOfRedis to keep that short. When a Redis command fails,
+// Redis is bypassed for RedisConfig.RetryInterval and lookups fall back to
+// the database.
+type Tiered struct {
+	local  *LRU
+	remote *Redis
+
+	downUntil atomic.Int64 // unix nanoseconds; zero while Redis is healthy
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	errors       atomic.Uint64
+}
+
+// NewTiered combines an in-memory cache with a Redis tier.
+func NewTiered(local *LRU, remote *Redis) *Tiered {
+	return &Tiered{local: local, remote: remote}
+}
+
+func (t *Tiered) Get(code string) (*models.LinkMapping, bool) {
+	if link, found := t.local.Get(code); found {
+		return link, true
+	}
+	if !t.available() {
+		return nil, false
+	}
+
+	ctx, cancel := t.context()
+	defer cancel()
+
+	link, found, err := t.remote.Get(ctx, code)
+	if err != nil {
+		t.fail("get", err)
+		return nil, false
+	}
+	t.recovered()
+
+	switch {
+	case !found:
+		t.misses.Add(1)
+		return nil, false
+	case link == nil:
+		t.negativeHits.Add(1)
+		t.local.SetMissing(code)
+	default:
+		t.hits.Add(1)
+		t.local.Set(link)
+	}
+	return link, true
+}
+
+func (t *Tiered) Set(link *models.LinkMapping) {
+	t.local.Set(link)
+	t.remoteCall("set", func(ctx context.Context) error {
+		return t.remote.Set(ctx, link)
+	})
+}
+
+func (t *Tiered) Fill(link *models.LinkMapping) {
+	t.local.Fill(link)
+	t.remoteCall("set", func(ctx context.Context) error {
+		return t.remote.Fill(ctx, link)
+	})
+}
+
+func (t *Tiered) SetMissing(code string) {
+	t.local.SetMissing(code)
+	t.remoteCall("set", func(ctx context.Context) error {
+		return t.remote.SetMissing(ctx, code)
+	})
+}
+
+// Invalidate is attempted even while Redis is being bypassed: a skipped
+// delete would let Redis serve a stale link once it is reachable again.
+func (t *Tiered) Invalidate(code string) {
+	t.local.Invalidate(code)
+
+	ctx, cancel := t.context()
+	defer cancel()
+
+	if err := t.remote.Delete(ctx, code); err != nil {
+		t.fail("delete", err)
+		logger.Error("Redis cache may serve a stale entry for '%s' for up to %s: %v", code, t.remote.cfg.TTL, err)
+		return
+	}
+	t.recovered()
+}
+
+func (t *Tiered) Stats() Stats {
+	stats := t.local.Stats()
+	stats.Redis = &RedisStats{
+		Hits:         t.hits.Load(),
+		NegativeHits: t.negativeHits.Load(),
+		Misses:       t.misses.Load(),
+		Errors:       t.errors.Load(),
+		Available:    t.available(),
+	}
+	return stats
+}
+
+func (t *Tiered) remoteCall(op string, fn func(ctx context.Context) error) {
+	if !t.available() {
+		return
+	}
+
+	ctx, cancel := t.context()
+	defer cancel()
+
+	if err := fn(ctx); err != nil {
+		t.fail(op, err)
+		return
+	}
+	t.recovered()
+}
+
+func (t *Tiered) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), t.remote.cfg.Timeout)
+}
+
+func (t *Tiered) available() bool {
+	until := t.downUntil.Load()
+	return until == 0 || time.Now().UnixNano() >= until
+}
+
+// fail records a Redis error and bypasses Redis for the retry interval.
+// Only the first failure after a healthy period is logged.
+func (t *Tiered) fail(op string, err error) {
+	t.errors.Add(1)
+
+	until := time.Now().Add(t.remote.cfg.RetryInterval).UnixNano()
+	if t.downUntil.Swap(until) == 0 {
+		logger.Warn("Redis cache %s failed, falling back to the database for %s: %v", op, t.remote.cfg.RetryInterval, err)
+	}
+}
+
+// recovered clears the failure state after a successful Redis command.
+func (t *Tiered) recovered() {
+	if t.downUntil.Load() != 0 && t.downUntil.Swap(0) != 0 {
+		logger.Info("Redis cache is available again")
+	}
+}
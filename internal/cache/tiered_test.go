@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const testRetryInterval = 100 * time.Millisecond
+
+// newTestTiered returns a Tiered cache backed by mr, standing in for one
+// app instance. Caches created on the same server share the Redis tier.
+func newTestTiered(t *testing.T, mr *miniredis.Miniredis, localTTL time.Duration) *Tiered {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	remote := NewRedis(client, RedisConfig{
+		KeyPrefix:     "test:",
+		TTL:           time.Hour,
+		FillTTL:       time.Minute,
+		NegativeTTL:   time.Minute,
+		Timeout:       time.Second,
+		RetryInterval: testRetryInterval,
+	})
+	local := NewLRU(Config{MaxBytes: 1 << 20, TTL: 10 * time.Minute, NegativeTTL: time.Minute}.InFrontOfRedis(localTTL))
+	return NewTiered(local, remote)
+}
+
+func testLink(code string) *models.LinkMapping {
+	return &models.LinkMapping{ShortCode: code, OriginalURL: "https://example.com/" + code, CreatedAt: time.Unix(1_700_000_000, 0)}
+}
+
+func TestTieredSetWritesThrough(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestTiered(t, mr, time.Minute)
+	b := newTestTiered(t, mr, time.Minute)
+
+	a.Set(testLink("abc"))
+	if !mr.Exists("test:abc") {
+		t.Fatal("Set did not write the link to Redis")
+	}
+	if ttl := mr.TTL("test:abc"); ttl < time.Hour || ttl > time.Hour+6*time.Minute {
+		t.Errorf("Redis TTL = %s, want an hour plus up to 10%% jitter", ttl)
+	}
+
+	link, found := b.Get("abc")
+	if !found || link == nil || link.OriginalURL != "https://example.com/abc" {
+		t.Fatalf("other instance Get = %+v, %v; want the link from Redis", link, found)
+	}
+	if stats := b.Stats(); stats.Redis.Hits != 1 {
+		t.Errorf("Redis hits = %d, want 1", stats.Redis.Hits)
+	}
+
+	// The Redis hit is kept in memory, so the next lookup stays local
+	b.Get("abc")
+	if stats := b.Stats(); stats.Redis.Hits != 1 || stats.Hits != 1 {
+		t.Errorf("second lookup: Redis hits = %d, memory hits = %d; want 1 and 1", stats.Redis.Hits, stats.Hits)
+	}
+}
+
+func TestTieredInvalidate(t *testing.T) {
+	mr := miniredis.RunT(t)
+	localTTL := 50 * time.Millisecond
+	a := newTestTiered(t, mr, localTTL)
+	b := newTestTiered(t, mr, localTTL)
+
+	a.Set(testLink("abc"))
+	if _, found := b.Get("abc"); !found {
+		t.Fatal("other instance did not find the link")
+	}
+
+	a.Invalidate("abc")
+	if mr.Exists("test:abc") {
+		t.Error("Invalidate left the link in Redis")
+	}
+	if _, found := a.Get("abc"); found {
+		t.Error("Invalidate left the link in memory")
+	}
+
+	// Other instances only drop their in-memory copy once it expires
+	time.Sleep(localTTL + 10*time.Millisecond)
+	if _, found := b.Get("abc"); found {
+		t.Errorf("other instance still served the link after %s", localTTL)
+	}
+}
+
+func TestTieredFillExpiresSooner(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestTiered(t, mr, time.Minute)
+	b := newTestTiered(t, mr, time.Minute)
+
+	// A read that raced an update fills the old link after the update
+	// invalidated it, so it must not linger for the full TTL
+	a.Fill(testLink("abc"))
+	if ttl := mr.TTL("test:abc"); ttl < time.Minute || ttl > time.Minute+6*time.Second {
+		t.Errorf("Redis TTL after Fill = %s, want a minute plus up to 10%% jitter", ttl)
+	}
+	if link, found := b.Get("abc"); !found || link == nil {
+		t.Errorf("other instance Get = %+v, %v; want the filled link", link, found)
+	}
+
+	mr.FastForward(time.Minute + 6*time.Second)
+	if mr.Exists("test:abc") {
+		t.Error("filled link outlived FillTTL")
+	}
+}
+
+func TestTieredZeroLocalTTLSkipsMemory(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestTiered(t, mr, 0)
+	b := newTestTiered(t, mr, 0)
+
+	a.Set(testLink("abc"))
+	b.Get("abc")
+	a.Invalidate("abc")
+
+	if _, found := b.Get("abc"); found {
+		t.Error("other instance served an invalidated link")
+	}
+}
+
+func TestTieredNegativeEntries(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestTiered(t, mr, time.Minute)
+	b := newTestTiered(t, mr, time.Minute)
+
+	a.SetMissing("nope")
+	if !mr.Exists("test:nope") {
+		t.Fatal("SetMissing did not write to Redis")
+	}
+	if ttl := mr.TTL("test:nope"); ttl > time.Minute+6*time.Second {
+		t.Errorf("negative entry TTL = %s, want about a minute", ttl)
+	}
+
+	link, found := b.Get("nope")
+	if !found || link != nil {
+		t.Fatalf("other instance Get = %+v, %v; want a cached miss", link, found)
+	}
+	if stats := b.Stats(); stats.Redis.NegativeHits != 1 {
+		t.Errorf("Redis negative hits = %d, want 1", stats.Redis.NegativeHits)
+	}
+
+	// Creating the link replaces the negative entry
+	a.Set(testLink("nope"))
+	a.Invalidate("nope")
+	if _, found := a.Get("nope"); found {
+		t.Error("negative entry survived Set and Invalidate")
+	}
+}
+
+func TestTieredBypassesRedisAfterFailure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestTiered(t, mr, time.Minute)
+
+	mr.SetError("connection lost")
+	if _, found := c.Get("abc"); found {
+		t.Fatal("Get found a link while Redis was failing")
+	}
+	stats := c.Stats()
+	if stats.Redis.Errors != 1 || stats.Redis.Available {
+		t.Fatalf("after a failure: errors = %d, available = %v; want 1 and false", stats.Redis.Errors, stats.Redis.Available)
+	}
+
+	// Within the retry interval Redis is not contacted, even once it works
+	mr.SetError("")
+	c.Set(testLink("abc"))
+	c.Get("missing")
+	if mr.Exists("test:abc") {
+		t.Error("Set wrote to Redis while it was being bypassed")
+	}
+	if stats := c.Stats(); stats.Redis.Errors != 1 || stats.Redis.Misses != 0 {
+		t.Errorf("while bypassed: errors = %d, misses = %d; want 1 and 0", stats.Redis.Errors, stats.Redis.Misses)
+	}
+
+	time.Sleep(testRetryInterval + 10*time.Millisecond)
+	c.Set(testLink("def"))
+	if !mr.Exists("test:def") {
+		t.Error("Set did not write to Redis after the retry interval")
+	}
+	if !c.Stats().Redis.Available {
+		t.Error("Redis not reported available after a successful command")
+	}
+}
+
+func TestTieredInvalidateWhileBypassed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestTiered(t, mr, time.Minute)
+
+	c.Set(testLink("abc"))
+	mr.SetError("connection lost")
+	c.Get("other")
+	mr.SetError("")
+
+	// Deletes are attempted anyway, so Redis does not serve the old link
+	// once it is used again
+	c.Invalidate("abc")
+	if mr.Exists("test:abc") {
+		t.Error("Invalidate skipped Redis while it was being bypassed")
+	}
+}
+
+func TestInFrontOfRedis(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		maxTTL time.Duration
+		want   Config
+	}{
+		{"caps TTL", Config{MaxBytes: 1, TTL: time.Hour, NegativeTTL: time.Minute}, 5 * time.Second, Config{MaxBytes: 1, TTL: 5 * time.Second, NegativeTTL: 5 * time.Second}},
+		{"caps no expiry", Config{MaxBytes: 1}, 5 * time.Second, Config{MaxBytes: 1, TTL: 5 * time.Second}},
+		{"keeps shorter TTL", Config{MaxBytes: 1, TTL: time.Second, NegativeTTL: time.Second}, 5 * time.Second, Config{MaxBytes: 1, TTL: time.Second, NegativeTTL: time.Second}},
+		{"zero disables", Config{MaxBytes: 1, TTL: time.Hour}, 0, Config{MaxBytes: 0, TTL: time.Hour}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cfg.InFrontOfRedis(tt.maxTTL); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,53 +2,125 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "modernc.org/sqlite"
 )
 
-const schema = `
-CREATE TABLE IF NOT EXISTS link_mappings (
-    short_code TEXT PRIMARY KEY,
-    original_url TEXT NOT NULL,
-    created_at INTEGER NOT NULL,
-    created_by TEXT,
-    click_count INTEGER DEFAULT 0,
-    last_accessed INTEGER
-);
-
-CREATE INDEX IF NOT EXISTS idx_created_at ON link_mappings(created_at);
-CREATE INDEX IF NOT EXISTS idx_click_count ON link_mappings(click_count);
-
-CREATE TABLE IF NOT EXISTS click_analytics (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    short_code TEXT NOT NULL,
-    timestamp INTEGER NOT NULL,
-    user_agent TEXT,
-    ip_address TEXT,
-    referrer TEXT,
-    FOREIGN KEY (short_code) REFERENCES link_mappings(short_code)
-);
-
-CREATE INDEX IF NOT EXISTS idx_short_code_timestamp ON click_analytics(short_code, timestamp);
-`
-
-func Initialize() (*sql.DB, error) {
+// DB is a connection pool together with the SQL dialect it speaks.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Config selects and locates the database.
+type Config struct {
+	Driver Dialect
+	// Path is the SQLite database file.
+	Path string
+	// URL is the PostgreSQL connection string.
+	URL string
+	// MaxOpenConns caps the connection pool size.
+	MaxOpenConns int
+}
+
+// ConfigFromEnv reads DATABASE_DRIVER (sqlite or postgres), DATABASE_PATH,
+// DATABASE_URL and DATABASE_MAX_OPEN_CONNS.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Driver:       SQLite,
+		Path:         Path(),
+		URL:          os.Getenv("DATABASE_URL"),
+		MaxOpenConns: 25,
+	}
+
+	switch strings.ToLower(os.Getenv("DATABASE_DRIVER")) {
+	case "postgres", "postgresql", "pgx":
+		cfg.Driver = Postgres
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("DATABASE_MAX_OPEN_CONNS")); err == nil && n > 0 {
+		cfg.MaxOpenConns = n
+	}
+
+	return cfg
+}
+
+// Initialize opens the configured database and brings its schema up to
+// date. Set DATABASE_AUTO_MIGRATE=false to require running `migrate up`
+// explicitly; startup then fails while migrations are pending.
+func Initialize() (*DB, error) {
+	db, err := Open(ConfigFromEnv())
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(os.Getenv("DATABASE_AUTO_MIGRATE"), "false") {
+		pending, err := Pending(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if len(pending) > 0 {
+			db.Close()
+			return nil, fmt.Errorf("%d pending migration(s), run `link-shortener migrate up`", len(pending))
+		}
+		return db, nil
+	}
+
+	if _, err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Path returns the SQLite database path from DATABASE_PATH.
+func Path() string {
 	dbPath := os.Getenv("DATABASE_PATH")
 	if dbPath == "" {
 		dbPath = "link_shortener.db"
 	}
+	return dbPath
+}
 
+// Open connects to the configured database without touching its schema.
+func Open(cfg Config) (*DB, error) {
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = 25
+	}
+
+	switch cfg.Driver {
+	case Postgres:
+		return openPostgres(cfg)
+	default:
+		return openSQLite(cfg)
+	}
+}
+
+// OpenSQLite connects to the SQLite database at dbPath.
+func OpenSQLite(dbPath string) (*DB, error) {
+	return Open(Config{Driver: SQLite, Path: dbPath})
+}
+
+func openSQLite(cfg Config) (*DB, error) {
 	// Ensure directory exists
-	dir := filepath.Dir(dbPath)
+	dir := filepath.Dir(cfg.Path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 
-	// Add SQLite connection parameters for better concurrency
-	connectionString := dbPath + "?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000&_foreign_keys=1&_busy_timeout=5000"
+	// Add SQLite connection parameters for better concurrency. The driver
+	// only applies _pragma parameters, so the busy timeout that makes a
+	// second instance wait for the migration lock is set that way.
+	connectionString := cfg.Path + "?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000&_foreign_keys=1&_busy_timeout=5000&_pragma=busy_timeout(5000)"
 	
 	db, err := sql.Open("sqlite", connectionString)
 	if err != nil {
@@ -56,18 +128,37 @@ func Initialize() (*sql.DB, error) {
 	}
 
 	// Configure connection pool for high concurrency
-	db.SetMaxOpenConns(25)    // Limit concurrent connections to prevent resource exhaustion
-	db.SetMaxIdleConns(25)    // Keep connections alive for reuse
+	db.SetMaxOpenConns(cfg.MaxOpenConns) // Limit concurrent connections to prevent resource exhaustion
+	db.SetMaxIdleConns(cfg.MaxOpenConns) // Keep connections alive for reuse
 	db.SetConnMaxLifetime(5 * time.Minute) // Rotate connections periodically
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	// Create schema
-	if _, err := db.Exec(schema); err != nil {
+	return &DB{DB: db, Dialect: SQLite}, nil
+}
+
+func openPostgres(cfg Config) (*DB, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("DATABASE_URL is required when DATABASE_DRIVER=postgres")
+	}
+
+	db, err := sql.Open("pgx", cfg.URL)
+	if err != nil {
 		return nil, err
 	}
 
-	return db, nil
-}
\ No newline at end of file
+	// Several app instances share the server, so keep fewer idle connections
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxOpenConns / 2)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, Dialect: Postgres}, nil
+}
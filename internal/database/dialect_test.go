@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM t WHERE a = ?`, `SELECT * FROM t WHERE a = $1`},
+		{`UPDATE t SET a = ?, b = ? WHERE c = ?`, `UPDATE t SET a = $1, b = $2 WHERE c = $3`},
+		{`SELECT * FROM t WHERE a = '?' AND b = ?`, `SELECT * FROM t WHERE a = '?' AND b = $1`},
+		{`SELECT 'it''s?' FROM t WHERE a = ?`, `SELECT 'it''s?' FROM t WHERE a = $1`},
+	}
+
+	for _, tt := range tests {
+		if got := Postgres.Rebind(tt.query); got != tt.want {
+			t.Errorf("Postgres.Rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		if got := SQLite.Rebind(tt.query); got != tt.query {
+			t.Errorf("SQLite.Rebind(%q) = %q, want it unchanged", tt.query, got)
+		}
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		// A single connection, so the temporary table stays visible
+		db.SetMaxOpenConns(1)
+
+		if _, err := db.Exec(`CREATE TEMP TABLE unique_test (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE)`); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+		insert := db.Dialect.Rebind(`INSERT INTO unique_test (id, name) VALUES (?, ?)`)
+		if _, err := db.Exec(insert, 1, "a"); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+
+		_, err := db.Exec(insert, 1, "b")
+		if !db.Dialect.IsUniqueViolation(err) {
+			t.Errorf("duplicate primary key: IsUniqueViolation(%v) = false", err)
+		}
+
+		_, err = db.Exec(insert, 2, "a")
+		if !db.Dialect.IsUniqueViolation(err) {
+			t.Errorf("duplicate unique column: IsUniqueViolation(%v) = false", err)
+		}
+
+		_, err = db.Exec(insert, 3, nil)
+		if err == nil || db.Dialect.IsUniqueViolation(err) {
+			t.Errorf("NOT NULL violation: IsUniqueViolation(%v) = true", err)
+		}
+
+		if db.Dialect.IsUniqueViolation(nil) || db.Dialect.IsUniqueViolation(errors.New("unique")) {
+			t.Error("IsUniqueViolation reported a non-database error")
+		}
+	})
+}
+
+// Migrations use ALTER TABLE ... DROP COLUMN, which SQLite added in 3.35.
+func TestSQLiteVersion(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	var version string
+	if err := db.QueryRow(`SELECT sqlite_version()`).Scan(&version); err != nil {
+		t.Fatalf("sqlite_version: %v", err)
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		t.Fatalf("parse SQLite version %q: %v", version, err)
+	}
+	if major < 3 || major == 3 && minor < 35 {
+		t.Errorf("SQLite %s does not support DROP COLUMN, want 3.35 or newer", version)
+	}
+}
+
+// forEachDialect runs fn against a fresh SQLite database and, when
+// DATABASE_URL is set, against that PostgreSQL database.
+func forEachDialect(t *testing.T, fn func(t *testing.T, db *DB)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		fn(t, db)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("DATABASE_URL")
+		if url == "" {
+			t.Skip("DATABASE_URL not set")
+		}
+		db, err := Open(Config{Driver: Postgres, URL: url})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		fn(t, db)
+	})
+}
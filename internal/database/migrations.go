@@ -0,0 +1,663 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/urlpolicy"
+)
+
+// Migration is a numbered, forward-only schema change. Versions must be
+// unique and increasing; never edit a migration once it has shipped, add a
+// new one instead. Up must work on every Dialect.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *Tx) error
+}
+
+// Tx is a migration transaction that knows which dialect it is running on.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: execDialect(`
+CREATE TABLE IF NOT EXISTS link_mappings (
+    short_code TEXT PRIMARY KEY,
+    original_url TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    created_by TEXT,
+    click_count INTEGER DEFAULT 0,
+    last_accessed INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_created_at ON link_mappings(created_at);
+CREATE INDEX IF NOT EXISTS idx_click_count ON link_mappings(click_count);
+
+CREATE TABLE IF NOT EXISTS click_analytics (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_code TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    user_agent TEXT,
+    ip_address TEXT,
+    referrer TEXT,
+    FOREIGN KEY (short_code) REFERENCES link_mappings(short_code)
+);
+
+CREATE INDEX IF NOT EXISTS idx_short_code_timestamp ON click_analytics(short_code, timestamp);
+`, `
+CREATE TABLE IF NOT EXISTS link_mappings (
+    short_code TEXT PRIMARY KEY,
+    original_url TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    created_by TEXT,
+    click_count BIGINT DEFAULT 0,
+    last_accessed BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_created_at ON link_mappings(created_at);
+CREATE INDEX IF NOT EXISTS idx_click_count ON link_mappings(click_count);
+
+CREATE TABLE IF NOT EXISTS click_analytics (
+    id BIGSERIAL PRIMARY KEY,
+    short_code TEXT NOT NULL REFERENCES link_mappings(short_code),
+    timestamp BIGINT NOT NULL,
+    user_agent TEXT,
+    ip_address TEXT,
+    referrer TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_short_code_timestamp ON click_analytics(short_code, timestamp);
+`),
+	},
+	{
+		Version: 2,
+		Name:    "click_event_ids",
+		Up: func(tx *Tx) error {
+			// Clicks from the journal carry event IDs, which the unique
+			// index uses to store a batch replayed after a crash only once
+			if err := tx.AddColumn("click_analytics", "event_id", "TEXT"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_click_event_id ON click_analytics(event_id)`)
+			return err
+		},
+	},
+	{
+		Version: 3,
+		Name:    "link_lifetime",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "expires_at", "BIGINT"); err != nil {
+				return err
+			}
+			return tx.AddColumn("link_mappings", "max_clicks", "BIGINT")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "fallback_urls",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "fallback_url", "TEXT"); err != nil {
+				return err
+			}
+			return tx.AddColumn("click_analytics", "reason", "TEXT")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "api_keys",
+		Up: func(tx *Tx) error {
+			_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS api_keys (
+    id %s,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    secret_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    last_used_at BIGINT,
+    revoked_at BIGINT
+)`, tx.Dialect.AutoIncrement()))
+			return err
+		},
+	},
+	{
+		Version: 6,
+		Name:    "users_and_sessions",
+		Up: func(tx *Tx) error {
+			_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS users (
+    id %s,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    token_hash TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    csrf_token TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`, tx.Dialect.AutoIncrement()))
+			return err
+		},
+	},
+	{
+		Version: 7,
+		Name:    "link_owners",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "owner_id", "BIGINT REFERENCES users(id)"); err != nil {
+				return err
+			}
+			if err := tx.AddColumn("api_keys", "user_id", "BIGINT REFERENCES users(id)"); err != nil {
+				return err
+			}
+			if err := tx.AddColumn("users", "is_admin", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+				return err
+			}
+			// Every existing user could do everything until now
+			_, err := tx.Exec(`
+UPDATE users SET is_admin = TRUE;
+CREATE INDEX IF NOT EXISTS idx_link_mappings_owner_id ON link_mappings(owner_id)`)
+			return err
+		},
+	},
+	{
+		Version: 8,
+		Name:    "user_roles",
+		Up: func(tx *Tx) error {
+			// Users who could create and edit their own links keep that
+			// as the creator role
+			if err := tx.AddColumn("users", "role", "TEXT NOT NULL DEFAULT 'creator'"); err != nil {
+				return err
+			}
+			// DROP COLUMN needs SQLite 3.35 or newer; the bundled
+			// modernc.org/sqlite has it
+			_, err := tx.Exec(`
+UPDATE users SET role = 'admin' WHERE is_admin = TRUE;
+ALTER TABLE users DROP COLUMN is_admin`)
+			return err
+		},
+	},
+	{
+		Version: 9,
+		Name:    "user_identities",
+		Up: execSQL(`
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    email TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id)`),
+	},
+	{
+		Version: 10,
+		Name:    "blocklist",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "disabled_at", "BIGINT"); err != nil {
+				return err
+			}
+			if err := tx.AddColumn("link_mappings", "disabled_reason", "TEXT"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS blocklist_events (
+    id %s,
+    action TEXT NOT NULL,
+    short_code TEXT,
+    url TEXT NOT NULL,
+    source TEXT,
+    entry TEXT,
+    actor TEXT NOT NULL,
+    created_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_blocklist_events_created_at ON blocklist_events(created_at)`, tx.Dialect.AutoIncrement()))
+			return err
+		},
+	},
+	{
+		Version: 11,
+		Name:    "idempotency",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "normalized_url", "TEXT"); err != nil {
+				return err
+			}
+			if err := backfillNormalizedURLs(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`
+CREATE INDEX IF NOT EXISTS idx_link_mappings_created_by_normalized_url ON link_mappings(created_by, normalized_url);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    content_type TEXT,
+    location TEXT,
+    body TEXT,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY (scope, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at)`)
+			return err
+		},
+	},
+	{
+		Version: 12,
+		Name:    "link_versions",
+		Up: func(tx *Tx) error {
+			_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS link_versions (
+    id %s,
+    short_code TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    original_url TEXT NOT NULL,
+    previous_url TEXT,
+    changed_by TEXT NOT NULL,
+    restored_version INTEGER,
+    created_at BIGINT NOT NULL,
+    UNIQUE (short_code, version)
+)`, tx.Dialect.AutoIncrement()))
+			if err != nil {
+				return err
+			}
+			// History starts here: existing links get their current
+			// destination as version 1
+			_, err = tx.Exec(`
+INSERT INTO link_versions (short_code, version, original_url, changed_by, created_at)
+SELECT short_code, 1, original_url, COALESCE(created_by, ''), created_at FROM link_mappings`)
+			return err
+		},
+	},
+	{
+		Version: 13,
+		Name:    "soft_delete",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "deleted_at", "BIGINT"); err != nil {
+				return err
+			}
+			if err := tx.AddColumn("link_mappings", "deleted_by", "TEXT"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_link_mappings_deleted_at ON link_mappings(deleted_at)`)
+			return err
+		},
+	},
+	{
+		Version: 14,
+		Name:    "link_disabled_by",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "disabled_by", "TEXT"); err != nil {
+				return err
+			}
+			// Links disabled by hand so far were recorded only in their
+			// reason; everything else was taken down by the blocklist
+			_, err := tx.Exec(`
+UPDATE link_mappings SET disabled_by = SUBSTR(disabled_reason, 13)
+WHERE disabled_at IS NOT NULL AND disabled_reason LIKE 'disabled by %'`)
+			return err
+		},
+	},
+	{
+		Version: 15,
+		Name:    "archived_clicks",
+		Up: func(tx *Tx) error {
+			// Clicks on purged links move here. Unlike click_analytics it
+			// does not reference link_mappings, so it outlives the links
+			// and is not mixed up with a link that reuses the code.
+			_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS archived_clicks (
+    id %s,
+    event_id TEXT,
+    short_code TEXT NOT NULL,
+    timestamp BIGINT NOT NULL,
+    user_agent TEXT,
+    ip_address TEXT,
+    referrer TEXT,
+    reason TEXT,
+    archived_at BIGINT NOT NULL
+)`, tx.Dialect.AutoIncrement()))
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS idx_archived_clicks_short_code ON archived_clicks(short_code, timestamp)`)
+			return err
+		},
+	},
+	{
+		Version: 16,
+		Name:    "link_creator_keys",
+		Up: func(tx *Tx) error {
+			if err := tx.AddColumn("link_mappings", "created_by_key", "BIGINT REFERENCES api_keys(id)"); err != nil {
+				return err
+			}
+			// Links made by a service key were only recorded by the key's
+			// name; attribute them where no other service key has it
+			_, err := tx.Exec(`
+UPDATE link_mappings
+SET created_by_key = (SELECT MIN(id) FROM api_keys WHERE api_keys.name = link_mappings.created_by AND api_keys.user_id IS NULL)
+WHERE owner_id IS NULL AND created_by_key IS NULL
+  AND (SELECT COUNT(*) FROM api_keys WHERE api_keys.name = link_mappings.created_by AND api_keys.user_id IS NULL) = 1;
+
+CREATE INDEX IF NOT EXISTS idx_link_mappings_owner_normalized_url ON link_mappings(owner_id, normalized_url);
+CREATE INDEX IF NOT EXISTS idx_link_mappings_key_normalized_url ON link_mappings(created_by_key, normalized_url);`)
+			return err
+		},
+	},
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at BIGINT NOT NULL
+)`
+
+// Migrations returns every known migration in version order.
+func Migrations() []Migration {
+	return migrations
+}
+
+// Status lists every known migration along with when it was applied.
+func Status(db *DB) ([]MigrationStatus, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func Pending(db *DB) ([]Migration, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies all pending migrations, each in its own transaction, and
+// returns the ones it applied. Instances starting together against the same
+// database take turns, and each migration is applied by only one of them.
+func Migrate(db *DB) ([]Migration, error) {
+	var done []Migration
+	for {
+		m, err := migrateNext(db)
+		if err != nil {
+			return done, err
+		}
+		if m == nil {
+			return done, nil
+		}
+		done = append(done, *m)
+	}
+}
+
+// migrateNext applies the first pending migration and returns it, or nil
+// once there are none. What is pending is read only after taking the
+// migration lock, so a migration just applied by another instance is not
+// run again.
+func migrateNext(db *DB) (*Migration, error) {
+	tx, applied, err := beginMigration(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // No-op once committed
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := applyMigration(tx, m); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		return &m, nil
+	}
+
+	return nil, tx.Commit()
+}
+
+// DryRun applies all pending migrations inside a single transaction and
+// rolls it back, reporting the first migration that would fail.
+func DryRun(db *DB) ([]Migration, error) {
+	tx, applied, err := beginMigration(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	for _, m := range pending {
+		if err := applyMigration(tx, m); err != nil {
+			return pending, err
+		}
+	}
+
+	return pending, nil
+}
+
+// migrationLockID identifies the PostgreSQL advisory lock held while
+// migrating. It is an arbitrary constant shared by every instance.
+const migrationLockID = 7_238_462_019
+
+// beginMigration starts a transaction that holds the migration lock until
+// it ends, and returns it with the versions applied so far. PostgreSQL
+// takes an advisory lock; SQLite takes its database write lock up front
+// rather than at the migration's first write.
+func beginMigration(db *DB) (*Tx, map[int]time.Time, error) {
+	sqlTx, err := db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	tx := &Tx{Tx: sqlTx, Dialect: db.Dialect}
+
+	if db.Dialect == Postgres {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to take migration lock: %w", err)
+		}
+	}
+	if _, err := tx.Exec(createMigrationsTable); err != nil {
+		tx.Rollback()
+		return nil, nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	if db.Dialect == SQLite {
+		// A write, even one that changes nothing, takes the write lock
+		if _, err := tx.Exec(`UPDATE schema_migrations SET version = version WHERE 1 = 0`); err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to take migration lock: %w", err)
+		}
+	}
+
+	applied, err := readAppliedVersions(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	return tx, applied, nil
+}
+
+func applyMigration(tx *Tx, m Migration) error {
+	if err := m.Up(tx); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+	}
+
+	_, err := tx.Exec(tx.Dialect.Rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+		m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): failed to record version: %w", m.Version, m.Name, err)
+	}
+
+	return nil
+}
+
+func appliedVersions(db *DB) (map[int]time.Time, error) {
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return readAppliedVersions(db)
+}
+
+func readAppliedVersions(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}) (map[int]time.Time, error) {
+	rows, err := q.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+
+	return applied, rows.Err()
+}
+
+// backfillNormalizedURLs sets normalized_url on existing links, so that
+// reuse_existing also finds links created before it was recorded.
+func backfillNormalizedURLs(tx *Tx) error {
+	rows, err := tx.Query(`SELECT short_code, original_url FROM link_mappings WHERE normalized_url IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	normalized := make(map[string]string)
+	for rows.Next() {
+		var shortCode, originalURL string
+		if err := rows.Scan(&shortCode, &originalURL); err != nil {
+			return err
+		}
+		normalized[shortCode] = urlpolicy.Normalize(originalURL)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	update := tx.Dialect.Rebind(`UPDATE link_mappings SET normalized_url = ? WHERE short_code = ?`)
+	for shortCode, url := range normalized {
+		if _, err := tx.Exec(update, url, shortCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execSQL(statements string) func(tx *Tx) error {
+	return func(tx *Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// execDialect runs sqliteSQL or postgresSQL depending on the dialect, for
+// DDL that cannot be written portably.
+func execDialect(sqliteSQL, postgresSQL string) func(tx *Tx) error {
+	return func(tx *Tx) error {
+		statements := sqliteSQL
+		if tx.Dialect == Postgres {
+			statements = postgresSQL
+		}
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// AddColumn adds a column unless the table already has it, so migrations
+// also work on tables created by older schemas.
+func (tx *Tx) AddColumn(table, column, definition string) error {
+	if tx.Dialect == Postgres {
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		return err
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if found {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
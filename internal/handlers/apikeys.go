@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListAPIKeys handles GET /api/v1/keys
+func (h *Handlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeyService.ListKeys()
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.APIKeyListResponse{Keys: keys})
+}
+
+// CreateAPIKey handles POST /api/v1/keys
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	// Keys act for the named user, or for the caller by default
+	var user *models.User
+	if req.User != "" {
+		u, err := h.userService.GetUser(req.User)
+		if err != nil {
+			writeAPIKeyError(w, err)
+			return
+		}
+		user = u
+	} else if principal := authmiddleware.PrincipalFromContext(r.Context()); principal != nil && principal.UserID != 0 {
+		user = &models.User{ID: principal.UserID, Username: principal.Name}
+	}
+
+	key, secret, err := h.apiKeyService.CreateKey(req.Name, req.Scopes, user)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/keys/"+strconv.FormatInt(key.ID, 10))
+	writeJSON(w, http.StatusCreated, models.CreateAPIKeyResponse{APIKey: *key, Secret: secret})
+}
+
+// RevokeAPIKey handles DELETE /api/v1/keys/{id}
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "API key not found")
+		return
+	}
+
+	if err := h.apiKeyService.RevokeKey(id); err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAPIKeyError maps APIKeyService errors onto API status codes.
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		writeJSONError(w, http.StatusNotFound, "not_found", "API key not found")
+	case errors.Is(err, services.ErrUserNotFound):
+		writeJSONError(w, http.StatusBadRequest, "unknown_user", err.Error())
+	case errors.Is(err, services.ErrMissingKeyName):
+		writeJSONError(w, http.StatusBadRequest, "missing_name", err.Error())
+	case errors.Is(err, services.ErrMissingKeyScopes), errors.Is(err, services.ErrInvalidScope):
+		writeJSONError(w, http.StatusBadRequest, "invalid_scopes", err.Error())
+	default:
+		logger.Error("API key error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
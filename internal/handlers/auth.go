@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/services"
+)
+
+// loginPage is the data for login.html.
+type loginPage struct {
+	Title    string
+	Next     string
+	Username string
+	Error    string
+	// SSOName labels the single sign-on button, which is only shown when
+	// OIDC is configured.
+	SSOName string
+}
+
+// LoginPage handles GET /login
+func (h *Handlers) LoginPage(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirect(r.URL.Query().Get("next"))
+
+	// Already signed in
+	if cookie, err := r.Cookie(authmiddleware.SessionCookieName); err == nil {
+		if session, err := h.userService.AuthenticateSession(cookie.Value); err == nil && session != nil {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+	}
+
+	h.renderLogin(w, http.StatusOK, loginPage{Next: next})
+}
+
+// Login handles POST /login
+func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	username := r.PostFormValue("username")
+	next := safeRedirect(r.PostFormValue("next"))
+
+	session, token, err := h.userService.Login(username, r.PostFormValue("password"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			logger.Warn("Failed login for '%s' from %s", username, authmiddleware.ClientIP(r))
+			h.renderLogin(w, http.StatusUnauthorized, loginPage{Next: next, Username: username, Error: "Invalid username or password"})
+			return
+		}
+		logger.Error("Login failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, token, session.ExpiresAt)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Logout handles POST /logout
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(authmiddleware.SessionCookieName); err == nil {
+		if err := h.userService.Logout(cookie.Value); err != nil {
+			logger.Error("Failed to end session: %v", err)
+		}
+	}
+
+	setSessionCookie(w, "", time.Unix(0, 0))
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", "/login")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (h *Handlers) renderLogin(w http.ResponseWriter, status int, data loginPage) {
+	data.Title = "Sign In"
+	if h.oidcService != nil {
+		data.SSOName = h.oidcService.ProviderName()
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	if err := h.templates.ExecuteTemplate(w, "login.html", data); err != nil {
+		logger.Error("Template execution error: %v", err)
+	}
+}
+
+// setSessionCookie stores a session token in the browser; an empty token
+// and past expiry clear it. The cookie is Secure whenever BASE_URL is
+// served over HTTPS.
+func setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     authmiddleware.SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(getBaseURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// safeRedirect only allows redirects to paths on this site, so that the
+// login form cannot be used to send users elsewhere.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
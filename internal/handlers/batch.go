@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// maxBatchBodyBytes caps the size of batch request bodies, which may be
+// much larger than a single link's.
+const maxBatchBodyBytes = 1 << 20
+
+// batchCSVColumns are the CSV columns a batch may have, named like the
+// JSON fields.
+var batchCSVColumns = map[string]bool{
+	"original_url": true, "custom_code": true, "created_by": true, "expires_at": true,
+	"max_clicks": true, "fallback_url": true, "reuse_existing": true,
+}
+
+// CreateLinks handles POST /api/v1/links/batch
+func (h *Handlers) CreateLinks(w http.ResponseWriter, r *http.Request) {
+	var reqs []models.CreateShortURLRequest
+	var itemErrs []error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/csv":
+		var err error
+		reqs, itemErrs, err = decodeBatchCSV(w, r)
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+	case isJSONContent(r):
+		var body models.BatchCreateRequest
+		if err := decodeJSONBodyLimit(w, r, &body, maxBatchBodyBytes); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		reqs = body.Links
+		itemErrs = make([]error, len(reqs))
+	default:
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Sprintf("Unsupported content type %q; send application/json or text/csv", mediaType))
+		return
+	}
+
+	if err := h.shortenerService.CheckBatchSize(len(reqs)); err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	// Items that could not be read are reported without being sent on
+	var valid []models.CreateShortURLRequest
+	var positions []int
+	for i := range reqs {
+		req := &reqs[i]
+		req.OriginalURL = strings.TrimSpace(req.OriginalURL)
+		req.CustomCode = strings.TrimSpace(req.CustomCode)
+		req.FallbackURL = strings.TrimSpace(req.FallbackURL)
+		if itemErrs[i] == nil && req.OriginalURL == "" {
+			itemErrs[i] = &requestError{http.StatusBadRequest, "missing_original_url", "Original URL is required"}
+		}
+		if itemErrs[i] == nil {
+			valid = append(valid, *req)
+			positions = append(positions, i)
+		}
+	}
+
+	response := models.BatchCreateResponse{Results: make([]models.BatchCreateResult, len(reqs))}
+	if len(valid) > 0 {
+		results, err := h.shortenerService.CreateShortURLs(valid, authmiddleware.PrincipalFromContext(r.Context()))
+		if err != nil {
+			writeLinkError(w, err)
+			return
+		}
+		for j, result := range results {
+			i := positions[j]
+			if result.Err != nil {
+				itemErrs[i] = result.Err
+				continue
+			}
+			response.Results[i] = models.BatchCreateResult{
+				ShortCode:   result.Response.ShortCode,
+				ShortURL:    result.Response.ShortURL,
+				OriginalURL: result.Response.OriginalURL,
+				Reused:      result.Response.Reused,
+			}
+		}
+	}
+
+	for i := range response.Results {
+		response.Results[i].Index = i
+		if itemErrs[i] == nil {
+			response.Created++
+			continue
+		}
+		response.Failed++
+		response.Results[i].OriginalURL = reqs[i].OriginalURL
+		response.Results[i].Error = itemErrs[i].Error()
+		var reqErr *requestError
+		if errors.As(itemErrs[i], &reqErr) {
+			response.Results[i].Code = reqErr.code
+		} else {
+			response.Results[i].Code = createErrorCode(itemErrs[i])
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// decodeBatchCSV reads a CSV batch whose header row names the columns in
+// batchCSVColumns. Values that cannot be parsed fail their row only, which
+// is reported in itemErrs.
+func decodeBatchCSV(w http.ResponseWriter, r *http.Request) (reqs []models.CreateShortURLRequest, itemErrs []error, err error) {
+	reader := csv.NewReader(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, csvError(err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !batchCSVColumns[name] {
+			return nil, nil, &requestError{http.StatusBadRequest, "unknown_field", fmt.Sprintf("Unknown column %q", name)}
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, nil, &requestError{http.StatusBadRequest, "missing_original_url", "CSV header must include an original_url column"}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, csvError(err)
+		}
+
+		value := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		req := models.CreateShortURLRequest{
+			OriginalURL: value("original_url"),
+			CustomCode:  value("custom_code"),
+			FallbackURL: value("fallback_url"),
+		}
+		reqs = append(reqs, req)
+		itemErrs = append(itemErrs, parseBatchCSVRow(&reqs[len(reqs)-1], value))
+	}
+
+	return reqs, itemErrs, nil
+}
+
+// parseBatchCSVRow fills in the typed fields of a CSV row.
+func parseBatchCSVRow(req *models.CreateShortURLRequest, value func(string) string) error {
+	if v := value("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &requestError{http.StatusBadRequest, "invalid_expires_at", "expires_at must be an RFC 3339 timestamp"}
+		}
+		req.ExpiresAt = &t
+	}
+	if v := value("max_clicks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return &requestError{http.StatusBadRequest, "invalid_max_clicks", "max_clicks must be a whole number"}
+		}
+		req.MaxClicks = &n
+	}
+	if v := value("reuse_existing"); v != "" {
+		reuse, err := strconv.ParseBool(v)
+		if err != nil {
+			return &requestError{http.StatusBadRequest, "invalid_reuse_existing", "reuse_existing must be true or false"}
+		}
+		req.ReuseExisting = reuse
+	}
+	return nil
+}
+
+func csvError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &requestError{http.StatusRequestEntityTooLarge, "payload_too_large",
+			fmt.Sprintf("Request body must not exceed %d bytes", maxBatchBodyBytes)}
+	}
+	return &requestError{http.StatusBadRequest, "invalid_csv", err.Error()}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// DisableLink handles POST /api/v1/links/{code}/disable
+func (h *Handlers) DisableLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.shortenerService.DisableLink(chi.URLParam(r, "code"), authmiddleware.PrincipalFromContext(r.Context()))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, link)
+}
+
+// EnableLink handles POST /api/v1/links/{code}/enable
+func (h *Handlers) EnableLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.shortenerService.EnableLink(chi.URLParam(r, "code"), authmiddleware.PrincipalFromContext(r.Context()))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, link)
+}
+
+// ListBlocklistEvents handles GET /api/v1/blocklist/events
+func (h *Handlers) ListBlocklistEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := h.shortenerService.BlocklistEvents(getIntParam(r, "limit", 100))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.BlocklistEventListResponse{Events: events})
+}
+
+// recheckBlocklist disables links that have become blocklisted until Stop
+// is called or ctx is cancelled.
+func (h *Handlers) recheckBlocklist(ctx context.Context) {
+	ctx, cancel := h.untilStopped(ctx)
+	defer cancel()
+
+	h.shortenerService.RunBlocklistRecheck(ctx)
+}
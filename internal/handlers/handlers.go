@@ -1,38 +1,49 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/avantifellows/link-shortener/internal/blocklist"
+	"github.com/avantifellows/link-shortener/internal/cache"
+	"github.com/avantifellows/link-shortener/internal/journal"
 	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
 	"github.com/avantifellows/link-shortener/internal/models"
 	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/avantifellows/link-shortener/internal/shortcode"
+	"github.com/avantifellows/link-shortener/internal/storage"
+	"github.com/avantifellows/link-shortener/internal/urlpolicy"
 	"github.com/go-chi/chi/v5"
 )
 
-type ClickEvent struct {
-	ShortCode string
-	UserAgent string
-	IPAddress string
-	Referrer  string
-	Timestamp time.Time
-}
-
 type Handlers struct {
-	shortenerService *services.ShortenerService
-	templates        *template.Template
-	clickQueue       chan ClickEvent
+	shortenerService   *services.ShortenerService
+	idempotencyService *services.IdempotencyService
+	apiKeyService      *services.APIKeyService
+	userService        *services.UserService
+	oidcService        *services.OIDCService // nil unless OIDC is configured
+	templates          *template.Template
+	clicks             *journal.Journal
+	clickConsumer      *journal.Consumer
+
+	stopOnce sync.Once
+	stop     chan struct{} // closed by Stop to request a final flush
+	done     chan struct{} // closed once the click consumer has exited
 }
 
-func New(db *sql.DB) *Handlers {
+func New(store storage.Store, linkCache cache.Cache, clicks *journal.Journal, oidcService *services.OIDCService, blocked *blocklist.Blocklist, reserved *shortcode.Reserved, codes shortcode.CodeGenerator) *Handlers {
 	// Create template functions
 	funcMap := template.FuncMap{
 		"divf": func(a, b int) float64 {
@@ -64,11 +75,14 @@ func New(db *sql.DB) *Handlers {
 			}
 			return pages
 		},
-		"build_url": func(page int, pageSize int, searchTerm string) string {
+		"build_url": func(page int, pageSize int, searchTerm string, mine bool) string {
 			params := fmt.Sprintf("page=%d&size=%d", page, pageSize)
 			if searchTerm != "" {
 				params += fmt.Sprintf("&search=%s", strings.ReplaceAll(searchTerm, " ", "+"))
 			}
+			if mine {
+				params += "&mine=true"
+			}
 			return "/?" + params
 		},
 	}
@@ -76,72 +90,105 @@ func New(db *sql.DB) *Handlers {
 	// Load templates with functions
 	templates := template.Must(template.New("").Funcs(funcMap).ParseGlob("templates/*.html"))
 	
-	// Create click queue channel
-	clickQueue := make(chan ClickEvent, 10000) // Large buffer for traffic spikes
-	
 	h := &Handlers{
-		shortenerService: services.NewShortenerService(db),
-		templates:        templates,
-		clickQueue:       clickQueue,
+		shortenerService:   services.NewShortenerService(store, linkCache, blocked, reserved, codes),
+		idempotencyService: services.NewIdempotencyService(store),
+		apiKeyService:      services.NewAPIKeyService(store),
+		userService:        services.NewUserService(store),
+		oidcService:        oidcService,
+		templates:          templates,
+		clicks:             clicks,
+		stop:               make(chan struct{}),
+		done:               make(chan struct{}),
 	}
-	
-	// Start click processing goroutine
-	go h.processClickQueue()
+	h.clickConsumer = journal.NewConsumer(clicks, h.writeBatch)
 	
 	return h
 }
 
-func (h *Handlers) processClickQueue() {
-	batch := make([]ClickEvent, 0, 5000)
-	ticker := time.NewTicker(5 * time.Minute) // Production: 5 minutes
-	defer ticker.Stop()
-	
-	for {
+// Start replays any clicks left in the journal by a previous run and then
+// launches the background consumer that batches journaled clicks into the
+// database, along with the periodic blocklist recheck and purge of deleted
+// links. Cancelling ctx has the same effect as calling Stop.
+func (h *Handlers) Start(ctx context.Context) {
+	go h.processClicks(ctx)
+	go h.recheckBlocklist(ctx)
+	go h.purgeDeletedLinks(ctx)
+}
+
+// untilStopped returns a context that is cancelled along with ctx or when
+// Stop is called, for background jobs that have no shutdown work of their
+// own.
+func (h *Handlers) untilStopped(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
 		select {
-		case click := <-h.clickQueue:
-			batch = append(batch, click)
-			
-			// Production: flush when batch reaches 5000 clicks
-			if len(batch) >= 5000 {
-				logger.Debug("Flushing %d clicks to database", len(batch))
-				h.writeBatch(batch)
-				batch = batch[:0] // Reset slice
-			}
-			
-		case <-ticker.C:
-			// Time-based flush every 5 minutes (production)
-			if len(batch) > 0 {
-				logger.Debug("Timer flush: %d clicks to database", len(batch))
-				h.writeBatch(batch)
-				batch = batch[:0] // Reset slice
-			}
+		case <-h.stop:
+		case <-ctx.Done():
 		}
-	}
+		cancel()
+	}()
+	return ctx, cancel
 }
 
-func (h *Handlers) writeBatch(clicks []ClickEvent) {
-	if len(clicks) == 0 {
-		return
-	}
-	
-	tx, err := h.shortenerService.BeginTransaction()
-	if err != nil {
-		logger.Error("Failed to begin transaction for click batch: %v", err)
-		return
+// Stop applies every journaled click, closes the journal and waits for the
+// click consumer to exit. Call it after the HTTP server has shut down so no
+// new clicks are recorded, and before closing the database. It returns
+// ctx.Err() if the flush does not finish in time; unapplied clicks stay in
+// the journal and are replayed on the next start.
+func (h *Handlers) Stop(ctx context.Context) error {
+	h.stopOnce.Do(func() { close(h.stop) })
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	defer tx.Rollback() // Will be no-op if committed
-	
-	// Batch process all clicks in single transaction
-	for _, click := range clicks {
-		if err := h.shortenerService.TrackClickInTransaction(tx, click.ShortCode, click.UserAgent, click.IPAddress, click.Referrer, click.Timestamp); err != nil {
-			logger.Error("Failed to track click in batch for code '%s': %v", click.ShortCode, err)
-			// Continue processing other clicks
+}
+
+func (h *Handlers) processClicks(ctx context.Context) {
+	defer close(h.done)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-h.stop:
+		case <-runCtx.Done():
 		}
+		cancel()
+	}()
+
+	h.clickConsumer.Run(runCtx)
+
+	// Final flush before the database is closed
+	if err := h.clickConsumer.Drain(); err != nil {
+		logger.Error("Failed to flush click journal before shutdown: %v", err)
 	}
-	
-	if err := tx.Commit(); err != nil {
-		logger.Error("Failed to commit click batch: %v", err)
+	if err := h.clicks.Close(); err != nil {
+		logger.Error("Failed to close click journal: %v", err)
+	}
+}
+
+// writeBatch applies a batch of journaled clicks in a single transaction.
+// Clicks already recorded under the same event ID are skipped, so replaying
+// a batch after a crash does not double count.
+func (h *Handlers) writeBatch(events []journal.Event) error {
+	clicks := make([]models.ClickAnalytics, 0, len(events))
+	for _, e := range events {
+		clicks = append(clicks, models.ClickAnalytics{
+			EventID:   e.ID,
+			ShortCode: e.ShortCode,
+			Timestamp: e.Timestamp,
+			UserAgent: e.UserAgent,
+			IPAddress: e.IPAddress,
+			Referrer:  e.Referrer,
+			Counted:   e.Counted,
+			Reason:    e.Reason,
+		})
 	}
+
+	return h.shortenerService.RecordClicks(clicks)
 }
 
 func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
@@ -150,31 +197,59 @@ func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
+// statsResponse is the body of GET /api/v1/stats.
+type statsResponse struct {
+	LinkCache  cache.Stats              `json:"link_cache"`
+	ShortCodes shortcode.GeneratorStats `json:"short_codes"`
+}
+
+// Stats reports operational counters such as link cache hits and misses
+// and short code collisions.
+func (h *Handlers) Stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, statsResponse{
+		LinkCache:  h.shortenerService.CacheStats(),
+		ShortCodes: h.shortenerService.CodeStats(),
+	})
+}
+
 func (h *Handlers) Dashboard(w http.ResponseWriter, r *http.Request) {
 	// Get pagination and search parameters
 	page := getIntParam(r, "page", 1)
 	pageSize := getIntParam(r, "size", 50)
 	searchTerm := strings.TrimSpace(r.URL.Query().Get("search"))
+	owner := ownerFilter(r)
 
-	analytics, err := h.shortenerService.GetAnalyticsPaginated(page, pageSize, searchTerm)
+	analytics, err := h.shortenerService.GetAnalyticsPaginated(page, pageSize, searchTerm, owner)
 	if err != nil {
 		logger.Error("Error getting analytics: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	// The dashboard sits behind RequireLogin; its forms authenticate with
+	// the session cookie and echo the CSRF token
+	session := authmiddleware.SessionFromContext(r.Context())
+
 	data := struct {
 		Title      string
 		Analytics  *models.AnalyticsResponse
 		BaseURL    string
-		AuthToken  string
+		Username   string
+		Role       models.Role
+		CanCreate  bool
+		CSRFToken  string
 		SearchTerm string
+		Mine       bool
 	}{
 		Title:      "Link Shortener Dashboard",
 		Analytics:  analytics,
 		BaseURL:    getBaseURL(),
-		AuthToken:  getAuthToken(),
+		Username:   session.User.Username,
+		Role:       session.User.Role,
+		CanCreate:  session.User.Role.AtLeast(models.RoleCreator),
+		CSRFToken:  session.CSRFToken,
 		SearchTerm: searchTerm,
+		Mine:       owner != nil,
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -187,38 +262,37 @@ func (h *Handlers) Dashboard(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
-	// Parse form data
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+	req, err := decodeCreateRequest(w, r)
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			writeError(w, r, reqErr.status, reqErr.code, reqErr.message)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
-	req := models.CreateShortURLRequest{
-		OriginalURL: strings.TrimSpace(r.FormValue("original_url")),
-		CustomCode:  strings.TrimSpace(r.FormValue("custom_code")),
-		CreatedBy:   strings.TrimSpace(r.FormValue("created_by")),
-	}
-
 	if req.OriginalURL == "" {
-		http.Error(w, "Original URL is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "missing_original_url", "Original URL is required")
 		return
 	}
 
-	// Create short URL
-	response, err := h.shortenerService.CreateShortURL(req)
+	// Create short URL, owned by the authenticated caller
+	response, err := h.shortenerService.CreateShortURL(req, authmiddleware.PrincipalFromContext(r.Context()))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		status, code, message := linkErrorStatus(err)
+		writeError(w, r, status, code, message)
 		return
 	}
 
 	// Check if request accepts JSON (API call) or HTML (htmx/form)
-	if strings.Contains(r.Header.Get("Accept"), "application/json") {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, response)
 	} else {
 		// Return htmx partial template with success message
 		data := struct {
@@ -238,6 +312,122 @@ func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// decodeCreateRequest reads a CreateShortURLRequest from either a JSON body
+// (API clients) or form values (htmx dashboard and form-encoded clients).
+func decodeCreateRequest(w http.ResponseWriter, r *http.Request) (models.CreateShortURLRequest, error) {
+	var req models.CreateShortURLRequest
+
+	if isJSONContent(r) {
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			return req, err
+		}
+	} else {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "" && mediaType != "application/x-www-form-urlencoded" && mediaType != "multipart/form-data" {
+			return req, &requestError{http.StatusUnsupportedMediaType, "unsupported_media_type",
+				fmt.Sprintf("Unsupported content type %q", mediaType)}
+		}
+
+		// Parse form data
+		if err := r.ParseForm(); err != nil {
+			return req, &requestError{http.StatusBadRequest, "invalid_form", "Invalid form data"}
+		}
+
+		req = models.CreateShortURLRequest{
+			OriginalURL: r.FormValue("original_url"),
+			CustomCode:  r.FormValue("custom_code"),
+			FallbackURL: r.FormValue("fallback_url"),
+		}
+		// Checkboxes send "on"
+		if v := strings.TrimSpace(r.FormValue("reuse_existing")); v != "" {
+			reuse, err := strconv.ParseBool(v)
+			if err != nil && v != "on" {
+				return req, &requestError{http.StatusBadRequest, "invalid_reuse_existing", "reuse_existing must be true or false"}
+			}
+			req.ReuseExisting = reuse || v == "on"
+		}
+		if err := parseLifetimeForm(r, &req); err != nil {
+			return req, err
+		}
+	}
+
+	req.OriginalURL = strings.TrimSpace(req.OriginalURL)
+	req.CustomCode = strings.TrimSpace(req.CustomCode)
+	req.FallbackURL = strings.TrimSpace(req.FallbackURL)
+
+	return req, nil
+}
+
+// parseLifetimeForm reads the optional expiry and click limit form fields.
+// The expiry is given either as an RFC 3339 expires_at or, as the dashboard
+// sends it, a duration from now in expires_in.
+func parseLifetimeForm(r *http.Request, req *models.CreateShortURLRequest) error {
+	if v := strings.TrimSpace(r.FormValue("expires_at")); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &requestError{http.StatusBadRequest, "invalid_expires_at", "expires_at must be an RFC 3339 timestamp"}
+		}
+		req.ExpiresAt = &t
+	} else if v := strings.TrimSpace(r.FormValue("expires_in")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return &requestError{http.StatusBadRequest, "invalid_expires_at", "expires_in must be a duration such as 24h"}
+		}
+		t := time.Now().Add(d)
+		req.ExpiresAt = &t
+	}
+
+	if v := strings.TrimSpace(r.FormValue("max_clicks")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return &requestError{http.StatusBadRequest, "invalid_max_clicks", "max_clicks must be a whole number"}
+		}
+		req.MaxClicks = &n
+	}
+
+	return nil
+}
+
+// createErrorCode maps a CreateShortURL error to a machine-readable code.
+func createErrorCode(err error) string {
+	// Policy and blocklist rejections are reported by rule, prefixed for
+	// the fallback URL
+	rule := ""
+	var violation *urlpolicy.Violation
+	if errors.As(err, &violation) && violation.Rule != urlpolicy.RuleInvalid {
+		rule = violation.Rule
+	} else if errors.Is(err, services.ErrBlocklisted) {
+		rule = "blocklisted"
+	}
+	if rule != "" {
+		if errors.Is(err, services.ErrInvalidFallback) {
+			return "fallback_" + rule
+		}
+		return rule
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidURL):
+		return "invalid_url"
+	case errors.Is(err, services.ErrInvalidCustomCode):
+		return "invalid_custom_code"
+	case errors.Is(err, services.ErrReservedCode):
+		return "reserved_code"
+	case errors.Is(err, services.ErrOffensiveCode):
+		return "offensive_code"
+	case errors.Is(err, services.ErrCodeExists):
+		return "code_exists"
+	case errors.Is(err, services.ErrInvalidExpiry):
+		return "invalid_expires_at"
+	case errors.Is(err, services.ErrInvalidMaxClicks):
+		return "invalid_max_clicks"
+	case errors.Is(err, services.ErrInvalidFallback):
+		return "invalid_fallback_url"
+	default:
+		return "create_failed"
+	}
+}
+
 func (h *Handlers) RedirectURL(w http.ResponseWriter, r *http.Request) {
 	shortCode := chi.URLParam(r, "code")
 	if shortCode == "" {
@@ -248,39 +438,57 @@ func (h *Handlers) RedirectURL(w http.ResponseWriter, r *http.Request) {
 
 	logger.Debug("RedirectURL: looking up code '%s'", shortCode)
 
-	// Get original URL
-	originalURL, err := h.shortenerService.GetOriginalURL(shortCode)
+	// Get the destination, enforcing the link's expiry and click limit
+	now := time.Now()
+	redirect, err := h.shortenerService.ResolveRedirect(shortCode, now)
 	if err != nil {
 		logger.Error("RedirectURL: failed to get URL for code '%s': %v", shortCode, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	logger.Debug("RedirectURL: found URL '%s' for code '%s'", originalURL, shortCode)
+	if redirect.Reason != "" {
+		logger.Debug("RedirectURL: code '%s' is inactive (%s), fallback '%s'", shortCode, redirect.Reason, redirect.Destination)
+	} else {
+		logger.Debug("RedirectURL: found URL '%s' for code '%s'", redirect.Destination, shortCode)
+	}
 
-	// Track click analytics using async queue
+	// Track click analytics via the durable click journal
 	userAgent := r.Header.Get("User-Agent")
-	ipAddress := getClientIP(r)
+	ipAddress := authmiddleware.ClientIP(r)
 	referrer := r.Header.Get("Referer")
 
-	// Non-blocking send to click queue
-	select {
-	case h.clickQueue <- ClickEvent{
+	// Record the click in the journal; the consumer writes it to the database
+	if err := h.clicks.Append(journal.Event{
+		ID:        journal.NewEventID(),
 		ShortCode: shortCode,
 		UserAgent: userAgent,
 		IPAddress: ipAddress,
 		Referrer:  referrer,
-		Timestamp: time.Now(),
-	}:
-		// Click queued successfully
-	default:
-		// Queue full - drop click (graceful degradation)
-		logger.Warn("Click queue full, dropping click for code '%s'", shortCode)
+		Timestamp: now,
+		Counted:   redirect.Counted,
+		Reason:    redirect.Reason,
+	}); err != nil {
+		// Journal unavailable - drop click (graceful degradation)
+		logger.Warn("Failed to journal click for code '%s': %v", shortCode, err)
 	}
 
-	logger.Debug("RedirectURL: redirecting '%s' to '%s'", shortCode, originalURL)
-	// Redirect to original URL
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	if redirect.Reason == models.LinkStateDeleted {
+		http.Error(w, "This link has been deleted", http.StatusGone)
+		return
+	}
+	if redirect.Reason == models.LinkStateDisabled && redirect.Link.TakenDown() {
+		http.Error(w, "This link has been disabled", http.StatusGone)
+		return
+	}
+	if redirect.Destination == "" {
+		http.Error(w, "This link is no longer available", http.StatusGone)
+		return
+	}
+
+	logger.Debug("RedirectURL: redirecting '%s' to '%s'", shortCode, redirect.Destination)
+	// Redirect to original URL, or the fallback for an inactive link
+	http.Redirect(w, r, redirect.Destination, http.StatusFound)
 }
 
 func (h *Handlers) Analytics(w http.ResponseWriter, r *http.Request) {
@@ -288,8 +496,9 @@ func (h *Handlers) Analytics(w http.ResponseWriter, r *http.Request) {
 	page := getIntParam(r, "page", 1)
 	pageSize := getIntParam(r, "size", 50)
 	searchTerm := strings.TrimSpace(r.URL.Query().Get("search"))
+	owner := ownerFilter(r)
 
-	analytics, err := h.shortenerService.GetAnalyticsPaginated(page, pageSize, searchTerm)
+	analytics, err := h.shortenerService.GetAnalyticsPaginated(page, pageSize, searchTerm, owner)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -305,10 +514,12 @@ func (h *Handlers) Analytics(w http.ResponseWriter, r *http.Request) {
 			Analytics  *models.AnalyticsResponse
 			BaseURL    string
 			SearchTerm string
+			Mine       bool
 		}{
 			Analytics:  analytics,
 			BaseURL:    getBaseURL(),
 			SearchTerm: searchTerm,
+			Mine:       owner != nil,
 		}
 
 		w.Header().Set("Content-Type", "text/html")
@@ -319,23 +530,19 @@ func (h *Handlers) Analytics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for proxies)
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// Take the first IP if multiple are present
-		ips := strings.Split(forwarded, ",")
-		return strings.TrimSpace(ips[0])
+// ownerFilter returns the owner to filter links by: the caller's user when
+// the mine query parameter is true, and nil for all links otherwise.
+// Anonymous callers and keys without a user own no links.
+func ownerFilter(r *http.Request) *int64 {
+	if mine, _ := strconv.ParseBool(r.URL.Query().Get("mine")); !mine {
+		return nil
 	}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+	var userID int64
+	if principal := authmiddleware.PrincipalFromContext(r.Context()); principal != nil {
+		userID = principal.UserID
 	}
-
-	// Fall back to RemoteAddr
-	return strings.Split(r.RemoteAddr, ":")[0]
+	return &userID
 }
 
 func getBaseURL() string {
@@ -346,10 +553,6 @@ func getBaseURL() string {
 	return baseURL
 }
 
-func getAuthToken() string {
-	return os.Getenv("AUTH_TOKEN")
-}
-
 func getIntParam(r *http.Request, paramName string, defaultValue int) int {
 	param := r.URL.Query().Get(paramName)
 	if param == "" {
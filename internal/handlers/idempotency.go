@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotent makes requests carrying an Idempotency-Key header safe to
+// retry: the first response for a key is stored and sent again, marked
+// with Idempotent-Replayed, for retries of the same request by the same
+// API key or user. Server errors are not stored, so those requests can be
+// retried for real. It must run after AuthMiddleware.
+func (h *Handlers) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeError(w, r, http.StatusBadRequest, "invalid_idempotency_key",
+				fmt.Sprintf("Idempotency-Key must be 1 to %d printable ASCII characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		// Handlers enforce their own, possibly smaller, limits afterwards
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, "payload_too_large",
+					fmt.Sprintf("Request body must not exceed %d bytes", maxBatchBodyBytes))
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := authmiddleware.ByPrincipal(r)
+		stored, err := h.idempotencyService.Begin(scope, key, requestHash(r, body))
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			writeError(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused", err.Error())
+			return
+		case errors.Is(err, services.ErrIdempotencyInProgress):
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, http.StatusConflict, "idempotency_in_progress", err.Error())
+			return
+		case err != nil:
+			logger.Error("Failed to look up idempotency key: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		case stored != nil:
+			replay(w, stored)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := h.idempotencyService.Release(scope, key); err != nil {
+				logger.Error("Failed to release idempotency key: %v", err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		err = h.idempotencyService.Complete(&models.IdempotencyRecord{
+			Scope:       scope,
+			Key:         key,
+			StatusCode:  rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Location:    rec.Header().Get("Location"),
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			logger.Error("Failed to store idempotent response: %v", err)
+			return
+		}
+		completed = true
+	})
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHash fingerprints what a request asks for, so that a key reused
+// for another request is noticed.
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, record *models.IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	if record.Location != "" {
+		w.Header().Set("Location", record.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
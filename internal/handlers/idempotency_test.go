@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+// testIdempotencyPrincipal is the API key every test request is sent as.
+var testIdempotencyPrincipal = &models.Principal{KeyID: 1, Name: "ci"}
+
+// newIdempotencyTestHandlers returns handlers with only the idempotency
+// service, which is all Idempotent needs, and the store behind it.
+func newIdempotencyTestHandlers(t *testing.T) (*Handlers, *storage.SQLStore) {
+	t.Helper()
+
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	store := storage.NewSQLStore(db)
+	return &Handlers{idempotencyService: services.NewIdempotencyService(store)}, store
+}
+
+// countingHandler answers 201 with a body naming how many times it ran,
+// unless status is set.
+type countingHandler struct {
+	calls  int
+	status int
+	// during, if set, runs while the request is being handled.
+	during func()
+}
+
+func (c *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.calls++
+	if c.during != nil {
+		c.during()
+	}
+	if c.status != 0 {
+		writeError(w, r, c.status, "internal_error", "Internal server error")
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/links/abc")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"calls": c.calls, "request": string(body)})
+}
+
+func idempotentRequest(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	req = req.WithContext(authmiddleware.WithPrincipal(req.Context(), testIdempotencyPrincipal))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error response %q: %v", rec.Body, err)
+	}
+	return resp.Code
+}
+
+func TestIdempotentReplaysResponse(t *testing.T) {
+	h, _ := newIdempotencyTestHandlers(t)
+	next := &countingHandler{}
+	handler := h.Idempotent(next)
+
+	first := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first request = %d, replayed %q; want 201, not replayed", first.Code, first.Header().Get("Idempotent-Replayed"))
+	}
+
+	retry := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	if next.calls != 1 {
+		t.Errorf("handler ran %d times, want 1", next.calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %d, replayed %q; want 201, replayed", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retry body = %q, want the first response %q", retry.Body, first.Body)
+	}
+	for _, header := range []string{"Content-Type", "Location"} {
+		if retry.Header().Get(header) != first.Header().Get(header) {
+			t.Errorf("retry %s = %q, want %q", header, retry.Header().Get(header), first.Header().Get(header))
+		}
+	}
+
+	// Keys belong to the principal that sent them
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "k1")
+	req = req.WithContext(authmiddleware.WithPrincipal(req.Context(), &models.Principal{KeyID: 2, Name: "other"}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if next.calls != 2 || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another API key's request was replayed (%d calls)", next.calls)
+	}
+}
+
+func TestIdempotentRefusesReusedKey(t *testing.T) {
+	h, _ := newIdempotencyTestHandlers(t)
+	next := &countingHandler{}
+	handler := h.Idempotent(next)
+
+	idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	rec := idempotentRequest(handler, "k1", `{"url":"https://example.org"}`)
+	if rec.Code != http.StatusUnprocessableEntity || errorCode(t, rec) != "idempotency_key_reused" {
+		t.Errorf("different request with the same key = %d %s, want 422 idempotency_key_reused", rec.Code, rec.Body)
+	}
+	if next.calls != 1 {
+		t.Errorf("handler ran %d times, want 1", next.calls)
+	}
+}
+
+func TestIdempotentRefusesConcurrentRetry(t *testing.T) {
+	h, _ := newIdempotencyTestHandlers(t)
+	next := &countingHandler{}
+	handler := h.Idempotent(next)
+
+	// The retry arrives while the first request is still being handled
+	var retry *httptest.ResponseRecorder
+	next.during = func() {
+		next.during = nil
+		retry = idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	}
+
+	first := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request = %d, want 201", first.Code)
+	}
+	if retry.Code != http.StatusConflict || errorCode(t, retry) != "idempotency_in_progress" || retry.Header().Get("Retry-After") != "1" {
+		t.Errorf("concurrent retry = %d %s, Retry-After %q; want 409 idempotency_in_progress, 1",
+			retry.Code, retry.Body, retry.Header().Get("Retry-After"))
+	}
+	if next.calls != 1 {
+		t.Errorf("handler ran %d times, want 1", next.calls)
+	}
+
+	// Once the first request is done, retries get its response
+	if rec := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after the first request finished = %d, not replayed", rec.Code)
+	}
+}
+
+func TestIdempotentReleasesKeyAfterServerError(t *testing.T) {
+	h, store := newIdempotencyTestHandlers(t)
+	next := &countingHandler{status: http.StatusInternalServerError}
+	handler := h.Idempotent(next)
+
+	if rec := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failing request = %d, want 500", rec.Code)
+	}
+	if _, err := store.GetIdempotencyKey("key:1", "k1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("key after a server error: %v, want it released", err)
+	}
+
+	next.status = 0
+	rec := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" || next.calls != 2 {
+		t.Errorf("retry after a server error = %d, replayed %q, %d calls; want it handled again",
+			rec.Code, rec.Header().Get("Idempotent-Replayed"), next.calls)
+	}
+}
+
+func TestIdempotentTakesOverStalePendingKey(t *testing.T) {
+	h, store := newIdempotencyTestHandlers(t)
+	next := &countingHandler{}
+	handler := h.Idempotent(next)
+
+	// Claimed by a request that died with the server, long enough ago
+	claimed := time.Now().Add(-time.Hour)
+	err := store.CreateIdempotencyKey(&models.IdempotencyRecord{
+		Scope:       "key:1",
+		Key:         "k1",
+		RequestHash: "from the request that died",
+		CreatedAt:   claimed,
+		ExpiresAt:   claimed.Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	rec := idempotentRequest(handler, "k1", `{"url":"https://example.com"}`)
+	if rec.Code != http.StatusCreated || next.calls != 1 {
+		t.Fatalf("request with a stale pending key = %d %s, %d calls; want it handled", rec.Code, rec.Body, next.calls)
+	}
+	record, err := store.GetIdempotencyKey("key:1", "k1")
+	if err != nil || !record.Completed() || record.StatusCode != http.StatusCreated {
+		t.Errorf("key after taking it over = %+v, %v; want the new response stored", record, err)
+	}
+}
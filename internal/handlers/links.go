@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListLinks handles GET /api/v1/links
+func (h *Handlers) ListLinks(w http.ResponseWriter, r *http.Request) {
+	page := getIntParam(r, "page", 1)
+	pageSize := getIntParam(r, "size", 50)
+	searchTerm := strings.TrimSpace(r.URL.Query().Get("search"))
+	deleted, _ := strconv.ParseBool(r.URL.Query().Get("deleted"))
+
+	links, err := h.shortenerService.ListLinks(page, pageSize, searchTerm, ownerFilter(r), deleted)
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, links)
+}
+
+// CreateLink handles POST /api/v1/links
+func (h *Handlers) CreateLink(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateShortURLRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	req.OriginalURL = strings.TrimSpace(req.OriginalURL)
+	req.CustomCode = strings.TrimSpace(req.CustomCode)
+	req.FallbackURL = strings.TrimSpace(req.FallbackURL)
+
+	if req.OriginalURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing_original_url", "Original URL is required")
+		return
+	}
+
+	response, err := h.shortenerService.CreateShortURL(req, authmiddleware.PrincipalFromContext(r.Context()))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	link, err := h.shortenerService.GetLink(response.ShortCode)
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	if response.Reused {
+		writeJSON(w, http.StatusOK, link)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/links/"+link.ShortCode)
+	writeJSON(w, http.StatusCreated, link)
+}
+
+// GetLink handles GET /api/v1/links/{code}
+func (h *Handlers) GetLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.shortenerService.GetLink(chi.URLParam(r, "code"))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, link)
+}
+
+// UpdateLink handles PATCH /api/v1/links/{code}
+func (h *Handlers) UpdateLink(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateLinkRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	if req.OriginalURL != nil {
+		trimmed := strings.TrimSpace(*req.OriginalURL)
+		req.OriginalURL = &trimmed
+	}
+	if req.FallbackURL.Value != nil {
+		trimmed := strings.TrimSpace(*req.FallbackURL.Value)
+		req.FallbackURL.Value = &trimmed
+	}
+
+	link, err := h.shortenerService.UpdateLink(chi.URLParam(r, "code"), req, authmiddleware.PrincipalFromContext(r.Context()))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, link)
+}
+
+// DeleteLink handles DELETE /api/v1/links/{code}
+func (h *Handlers) DeleteLink(w http.ResponseWriter, r *http.Request) {
+	if err := h.shortenerService.DeleteLink(chi.URLParam(r, "code"), authmiddleware.PrincipalFromContext(r.Context())); err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreLink handles POST /api/v1/links/{code}/restore
+func (h *Handlers) RestoreLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.shortenerService.RestoreLink(chi.URLParam(r, "code"), authmiddleware.PrincipalFromContext(r.Context()))
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, link)
+}
+
+// purgeDeletedLinks purges deleted links past their retention until Stop
+// is called or ctx is cancelled.
+func (h *Handlers) purgeDeletedLinks(ctx context.Context) {
+	ctx, cancel := h.untilStopped(ctx)
+	defer cancel()
+
+	h.shortenerService.RunDeletedLinkPurge(ctx)
+}
+
+// writeRequestError reports a body decoding failure as a JSON envelope.
+func writeRequestError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		writeJSONError(w, reqErr.status, reqErr.code, reqErr.message)
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+}
+
+// writeLinkError maps ShortenerService errors onto API status codes.
+func writeLinkError(w http.ResponseWriter, err error) {
+	status, code, message := linkErrorStatus(err)
+	writeJSONError(w, status, code, message)
+}
+
+// linkErrorStatus returns the status, code and message reported for a
+// ShortenerService error. Unexpected errors are logged and reported without
+// their details.
+func linkErrorStatus(err error) (status int, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrLinkNotFound):
+		return http.StatusNotFound, "not_found", "Short code not found"
+	case errors.Is(err, services.ErrTakenDown):
+		return http.StatusForbidden, "taken_down", err.Error()
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden, "forbidden", err.Error()
+	case errors.Is(err, services.ErrCodeExists):
+		return http.StatusConflict, "code_exists", err.Error()
+	case errors.Is(err, services.ErrLinkDeleted):
+		return http.StatusConflict, "link_deleted", err.Error()
+	case errors.Is(err, services.ErrNotDeleted):
+		return http.StatusConflict, "not_deleted", err.Error()
+	case errors.Is(err, services.ErrNotDisabled):
+		return http.StatusConflict, "not_disabled", err.Error()
+	case errors.Is(err, services.ErrAlreadyDisabled):
+		return http.StatusConflict, "already_disabled", err.Error()
+	case errors.Is(err, services.ErrStillBlocklisted):
+		return http.StatusConflict, "still_blocklisted", err.Error()
+	case errors.Is(err, services.ErrBatchEmpty):
+		return http.StatusBadRequest, "empty_batch", err.Error()
+	case errors.Is(err, services.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge, "too_many_links", err.Error()
+	case errors.Is(err, services.ErrInvalidURL), errors.Is(err, services.ErrInvalidCustomCode),
+		errors.Is(err, services.ErrReservedCode), errors.Is(err, services.ErrOffensiveCode),
+		errors.Is(err, services.ErrInvalidExpiry), errors.Is(err, services.ErrInvalidMaxClicks),
+		errors.Is(err, services.ErrInvalidFallback):
+		return http.StatusBadRequest, createErrorCode(err), err.Error()
+	default:
+		logger.Error("Link API error: %v", err)
+		return http.StatusInternalServerError, "internal_error", "Internal server error"
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/services"
+)
+
+const (
+	// oidcFlowCookie keeps a sign-in attempt's state, nonce and PKCE
+	// verifier while the browser is at the identity provider.
+	oidcFlowCookie = "ls_oidc"
+	oidcFlowPath   = "/auth/oidc"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+// oidcFlow is the value of oidcFlowCookie.
+type oidcFlow struct {
+	services.OIDCFlow
+	Next string `json:"next"`
+}
+
+// OIDCLogin handles GET /auth/oidc/login
+func (h *Handlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidcService == nil {
+		http.NotFound(w, r)
+		return
+	}
+	next := safeRedirect(r.URL.Query().Get("next"))
+
+	url, flow, err := h.oidcService.AuthCodeURL(r.Context())
+	if err != nil {
+		logger.Error("Failed to start OIDC sign-in: %v", err)
+		h.renderLogin(w, http.StatusBadGateway, loginPage{Next: next, Error: "Single sign-on is unavailable, please try again later"})
+		return
+	}
+
+	value, err := json.Marshal(oidcFlow{OIDCFlow: *flow, Next: next})
+	if err != nil {
+		logger.Error("Failed to encode OIDC flow: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	setOIDCFlowCookie(w, base64.RawURLEncoding.EncodeToString(value), time.Now().Add(oidcFlowTTL))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCCallback handles GET /auth/oidc/callback
+func (h *Handlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidcService == nil {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+
+	// The flow is single use whatever the outcome
+	flow, ok := readOIDCFlow(r)
+	setOIDCFlowCookie(w, "", time.Unix(0, 0))
+	if !ok || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		h.renderLogin(w, http.StatusBadRequest, loginPage{Error: "Sign-in expired or was started elsewhere, please try again"})
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		logger.Warn("OIDC sign-in refused by provider: %s %s", errCode, query.Get("error_description"))
+		h.renderLogin(w, http.StatusUnauthorized, loginPage{Next: flow.Next, Error: "Sign-in was cancelled or refused"})
+		return
+	}
+
+	identity, err := h.oidcService.Exchange(r.Context(), query.Get("code"), &flow.OIDCFlow)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOIDCDomainNotAllowed), errors.Is(err, services.ErrOIDCEmailNotVerified):
+			logger.Warn("OIDC sign-in refused from %s: %v", authmiddleware.ClientIP(r), err)
+			h.renderLogin(w, http.StatusForbidden, loginPage{Next: flow.Next, Error: "This account is not allowed to sign in"})
+		default:
+			logger.Error("OIDC sign-in failed: %v", err)
+			h.renderLogin(w, http.StatusBadGateway, loginPage{Next: flow.Next, Error: "Single sign-on failed, please try again"})
+		}
+		return
+	}
+
+	session, token, err := h.userService.LoginIdentity(identity, h.oidcService.DefaultRole())
+	if err != nil {
+		logger.Error("Failed to sign in %s: %v", identity.Email, err)
+		h.renderLogin(w, http.StatusInternalServerError, loginPage{Next: flow.Next, Error: "Sign-in failed, please try again"})
+		return
+	}
+
+	setSessionCookie(w, token, session.ExpiresAt)
+	http.Redirect(w, r, flow.Next, http.StatusSeeOther)
+}
+
+func readOIDCFlow(r *http.Request) (*oidcFlow, bool) {
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		return nil, false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, false
+	}
+
+	var flow oidcFlow
+	if err := json.Unmarshal(value, &flow); err != nil || flow.State == "" {
+		return nil, false
+	}
+	flow.Next = safeRedirect(flow.Next)
+	return &flow, true
+}
+
+// setOIDCFlowCookie stores a sign-in attempt; it has to survive the
+// top-level redirect back from the provider, hence SameSite=Lax.
+func setOIDCFlowCookie(w http.ResponseWriter, value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     oidcFlowPath,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(getBaseURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avantifellows/link-shortener/internal/blocklist"
+	"github.com/avantifellows/link-shortener/internal/cache"
+	"github.com/avantifellows/link-shortener/internal/database"
+	"github.com/avantifellows/link-shortener/internal/journal"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/mockoidc"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/avantifellows/link-shortener/internal/shortcode"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+const testCallbackURL = "http://shortener.test" + services.OIDCCallbackPath
+
+// newOIDCTestHandlers returns handlers signing in through a mock provider
+// that accepts example.org addresses.
+func newOIDCTestHandlers(t *testing.T) *Handlers {
+	t.Helper()
+
+	provider := httptest.NewServer(nil)
+	t.Cleanup(provider.Close)
+	mock, err := mockoidc.New(provider.URL, "link-shortener", "secret")
+	if err != nil {
+		t.Fatalf("mock provider: %v", err)
+	}
+	provider.Config.Handler = mock
+
+	oidcService, err := services.NewOIDCService(services.OIDCConfig{
+		IssuerURL:      provider.URL,
+		ClientID:       "link-shortener",
+		ClientSecret:   "secret",
+		RedirectURL:    testCallbackURL,
+		AllowedDomains: []string{"example.org"},
+		DefaultRole:    models.RoleViewer,
+		ProviderName:   "Mock",
+	})
+	if err != nil {
+		t.Fatalf("OIDC service: %v", err)
+	}
+
+	dir := t.TempDir()
+	db, err := database.OpenSQLite(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	clicks, err := journal.Open(journal.Config{Dir: filepath.Join(dir, "journal"), Sync: journal.SyncNever})
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	t.Cleanup(func() { clicks.Close() })
+
+	blocked, err := blocklist.New(blocklist.Config{})
+	if err != nil {
+		t.Fatalf("blocklist: %v", err)
+	}
+	reserved := shortcode.NewReserved(nil, nil)
+	codes, err := shortcode.NewRandomGenerator(shortcode.GeneratorConfigFromEnv(), reserved)
+	if err != nil {
+		t.Fatalf("code generator: %v", err)
+	}
+
+	// Templates are loaded relative to the repository root
+	t.Chdir("../..")
+	return New(storage.NewSQLStore(db), cache.NewLRU(cache.Config{}), clicks, oidcService, blocked, reserved, codes)
+}
+
+// startOIDCLogin runs the login handler and returns the provider URL it
+// redirects to, along with the flow cookie it sets.
+func startOIDCLogin(t *testing.T, h *Handlers) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.OIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?next=/links", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want 302: %s", rec.Code, rec.Body)
+	}
+
+	authorizeURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("login redirect: %v", err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcFlowCookie {
+			return authorizeURL, c
+		}
+	}
+	t.Fatal("login did not set the flow cookie")
+	return nil, nil
+}
+
+// authorize signs in at the provider as email and returns the query of
+// the callback it redirects back to.
+func authorize(t *testing.T, authorizeURL *url.URL, email string, verified bool) url.Values {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	form := url.Values{"email": {email}}
+	if verified {
+		form.Set("email_verified", "true")
+	}
+	resp, err := client.PostForm(authorizeURL.String(), form)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound || !strings.HasPrefix(callback.String(), testCallbackURL) {
+		t.Fatalf("authorize = %d %q, want a redirect to the callback", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return callback.Query()
+}
+
+func oidcCallback(h *Handlers, query url.Values, flow *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, services.OIDCCallbackPath+"?"+query.Encode(), nil)
+	if flow != nil {
+		req.AddCookie(flow)
+	}
+	rec := httptest.NewRecorder()
+	h.OIDCCallback(rec, req)
+	return rec
+}
+
+// signedInUser returns the user of the session the response started, or
+// nil if it started none.
+func signedInUser(t *testing.T, h *Handlers, rec *httptest.ResponseRecorder) *models.User {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name != authmiddleware.SessionCookieName || c.Value == "" {
+			continue
+		}
+		session, err := h.userService.AuthenticateSession(c.Value)
+		if err != nil || session == nil {
+			t.Fatalf("session cookie does not authenticate: %v", err)
+		}
+		return &session.User
+	}
+	return nil
+}
+
+func TestOIDCSignInCreatesUser(t *testing.T) {
+	h := newOIDCTestHandlers(t)
+
+	authorizeURL, flow := startOIDCLogin(t, h)
+	rec := oidcCallback(h, authorize(t, authorizeURL, "New.User@Example.org", true), flow)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/links" {
+		t.Fatalf("callback = %d %q, want 303 to /links: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	user := signedInUser(t, h, rec)
+	if user == nil {
+		t.Fatal("callback did not start a session")
+	}
+	if user.Username != "new.user@example.org" || user.Role != models.RoleViewer {
+		t.Errorf("signed in as %s (%s), want new.user@example.org (viewer)", user.Username, user.Role)
+	}
+
+	// The flow cookie is single use
+	rec = oidcCallback(h, authorize(t, authorizeURL, "new.user@example.org", true), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("callback without the flow cookie = %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCSignInLinksExistingUser(t *testing.T) {
+	h := newOIDCTestHandlers(t)
+
+	existing, err := h.userService.CreateUser("admin@example.org", "correct horse battery", models.RoleAdmin)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		authorizeURL, flow := startOIDCLogin(t, h)
+		rec := oidcCallback(h, authorize(t, authorizeURL, "admin@example.org", true), flow)
+
+		user := signedInUser(t, h, rec)
+		if user == nil {
+			t.Fatalf("sign-in %d: status %d, no session", i+1, rec.Code)
+		}
+		if user.ID != existing.ID || user.Role != models.RoleAdmin {
+			t.Errorf("sign-in %d: signed in as user %d (%s), want the existing admin %d", i+1, user.ID, user.Role, existing.ID)
+		}
+	}
+
+	users, err := h.userService.ListUsers()
+	if err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("%d users after signing in, want 1", len(users))
+	}
+}
+
+func TestOIDCCallbackRefusals(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		verified bool
+		// tamper changes the callback query or the flow kept in the cookie
+		tamper     func(query url.Values, flow *oidcFlow)
+		wantStatus int
+	}{
+		{
+			name: "state mismatch", email: "user@example.org", verified: true,
+			tamper:     func(query url.Values, _ *oidcFlow) { query.Set("state", "forged") },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "wrong nonce", email: "user@example.org", verified: true,
+			tamper:     func(_ url.Values, flow *oidcFlow) { flow.Nonce = "replayed" },
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "unverified email", email: "user@example.org", verified: false,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "disallowed domain", email: "user@example.com", verified: true,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "provider error", email: "user@example.org", verified: true,
+			tamper: func(query url.Values, _ *oidcFlow) {
+				query.Del("code")
+				query.Set("error", "access_denied")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newOIDCTestHandlers(t)
+
+			authorizeURL, cookie := startOIDCLogin(t, h)
+			query := authorize(t, authorizeURL, tt.email, tt.verified)
+			if tt.tamper != nil {
+				flow, ok := readOIDCFlow(&http.Request{Header: http.Header{"Cookie": {cookie.String()}}})
+				if !ok {
+					t.Fatal("flow cookie does not decode")
+				}
+				tt.tamper(query, flow)
+				value, _ := json.Marshal(flow)
+				cookie.Value = base64.RawURLEncoding.EncodeToString(value)
+			}
+
+			rec := oidcCallback(h, query, cookie)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("callback status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if user := signedInUser(t, h, rec); user != nil {
+				t.Errorf("refused callback signed in %s", user.Username)
+			}
+			if users, _ := h.userService.ListUsers(); len(users) != 0 {
+				t.Errorf("refused callback created %d users", len(users))
+			}
+		})
+	}
+}
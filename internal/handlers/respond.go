@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// maxJSONBodyBytes caps the size of JSON request bodies accepted by the API.
+const maxJSONBodyBytes = 64 << 10
+
+// requestError describes a client error detected while reading a request.
+type requestError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// isJSONContent reports whether the request body is declared as JSON.
+func isJSONContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// wantsJSON reports whether the response should be JSON rather than an htmx partial.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || isJSONContent(r)
+}
+
+// decodeJSONBody strictly decodes a single JSON object from the request body into dst.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return decodeJSONBodyLimit(w, r, dst, maxJSONBodyBytes)
+}
+
+// decodeJSONBodyLimit is decodeJSONBody for bodies of up to limit bytes.
+func decodeJSONBodyLimit(w http.ResponseWriter, r *http.Request, dst interface{}, limit int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var maxBytesErr *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesErr):
+			return &requestError{http.StatusRequestEntityTooLarge, "payload_too_large",
+				fmt.Sprintf("Request body must not exceed %d bytes", limit)}
+		case errors.As(err, &syntaxErr):
+			return &requestError{http.StatusBadRequest, "invalid_json",
+				fmt.Sprintf("Malformed JSON at position %d", syntaxErr.Offset)}
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return &requestError{http.StatusBadRequest, "invalid_json", "Malformed JSON"}
+		case errors.As(err, &typeErr):
+			return &requestError{http.StatusBadRequest, "invalid_json",
+				fmt.Sprintf("Invalid value for field %q", typeErr.Field)}
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return &requestError{http.StatusBadRequest, "unknown_field",
+				fmt.Sprintf("Unknown field %s", field)}
+		case errors.Is(err, io.EOF):
+			return &requestError{http.StatusBadRequest, "invalid_json", "Request body must not be empty"}
+		default:
+			return &requestError{http.StatusBadRequest, "invalid_json", "Malformed JSON"}
+		}
+	}
+
+	// Reject trailing data such as a second JSON object
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return &requestError{http.StatusBadRequest, "invalid_json", "Request body must contain a single JSON object"}
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Failed to encode JSON response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, models.ErrorResponse{Error: message, Code: code})
+}
+
+// writeError responds with a JSON envelope for API clients and plain text otherwise.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if wantsJSON(r) {
+		writeJSONError(w, status, code, message)
+		return
+	}
+	http.Error(w, message, status)
+}
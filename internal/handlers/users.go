@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListUsers handles GET /api/v1/users
+func (h *Handlers) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.userService.ListUsers()
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.UserListResponse{Users: users})
+}
+
+// CreateUser handles POST /api/v1/users
+func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleViewer
+	}
+
+	user, err := h.userService.CreateUser(req.Username, req.Password, req.Role)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/users/"+user.Username)
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// UpdateUser handles PATCH /api/v1/users/{username}
+func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	var req models.UpdateUserRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	user, err := h.userService.GetUser(username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if req.Password != nil {
+		if err := h.userService.SetPassword(username, *req.Password); err != nil {
+			writeUserError(w, err)
+			return
+		}
+	}
+	if req.Role != nil {
+		if user, err = h.userService.SetRole(username, *req.Role); err != nil {
+			writeUserError(w, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// DeleteUser handles DELETE /api/v1/users/{username}
+func (h *Handlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.userService.DeleteUser(chi.URLParam(r, "username")); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeUserError maps UserService errors onto API status codes.
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		writeJSONError(w, http.StatusNotFound, "not_found", "User not found")
+	case errors.Is(err, services.ErrUsernameTaken):
+		writeJSONError(w, http.StatusConflict, "username_taken", err.Error())
+	case errors.Is(err, services.ErrLastAdmin):
+		writeJSONError(w, http.StatusConflict, "last_admin", err.Error())
+	case errors.Is(err, services.ErrInvalidUsername):
+		writeJSONError(w, http.StatusBadRequest, "invalid_username", err.Error())
+	case errors.Is(err, services.ErrPasswordTooShort), errors.Is(err, services.ErrPasswordTooLong):
+		writeJSONError(w, http.StatusBadRequest, "invalid_password", err.Error())
+	case errors.Is(err, services.ErrInvalidRole):
+		writeJSONError(w, http.StatusBadRequest, "invalid_role", err.Error())
+	default:
+		logger.Error("User error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
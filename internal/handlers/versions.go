@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	authmiddleware "github.com/avantifellows/link-shortener/internal/middleware"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// LinkVersions handles GET /api/v1/links/{code}/versions. The dashboard
+// gets the history panel instead of JSON.
+func (h *Handlers) LinkVersions(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	if wantsHistoryPanel(r) {
+		h.renderLinkHistory(w, r, code, "", nil)
+		return
+	}
+
+	versions, err := h.shortenerService.LinkVersions(code)
+	if err != nil {
+		writeLinkError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.LinkVersionListResponse{Versions: versions})
+}
+
+// RollbackLink handles POST /api/v1/links/{code}/rollback
+func (h *Handlers) RollbackLink(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	panel := wantsHistoryPanel(r)
+
+	req, err := decodeRollbackRequest(w, r)
+	if err == nil {
+		_, err = h.shortenerService.RollbackLink(code, req.Version, authmiddleware.PrincipalFromContext(r.Context()))
+	}
+
+	if panel {
+		notice := ""
+		if err == nil {
+			notice = fmt.Sprintf("Rolled back to version %d", req.Version)
+		}
+		h.renderLinkHistory(w, r, code, notice, err)
+		return
+	}
+
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		writeRequestError(w, err)
+	case errors.Is(err, services.ErrVersionNotFound):
+		writeJSONError(w, http.StatusNotFound, "version_not_found", err.Error())
+	case err != nil:
+		writeLinkError(w, err)
+	default:
+		link, err := h.shortenerService.GetLink(code)
+		if err != nil {
+			writeLinkError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, link)
+	}
+}
+
+// decodeRollbackRequest reads the version to roll back to from a JSON body
+// (API clients) or form values (the dashboard).
+func decodeRollbackRequest(w http.ResponseWriter, r *http.Request) (models.RollbackLinkRequest, error) {
+	var req models.RollbackLinkRequest
+	if isJSONContent(r) {
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			return req, err
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return req, &requestError{http.StatusBadRequest, "invalid_request", "Failed to parse form"}
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(r.FormValue("version")))
+		if err != nil {
+			return req, &requestError{http.StatusBadRequest, "invalid_version", "version must be a whole number"}
+		}
+		req.Version = version
+	}
+
+	if req.Version < 1 {
+		return req, &requestError{http.StatusBadRequest, "invalid_version", "version must be at least 1"}
+	}
+	return req, nil
+}
+
+// wantsHistoryPanel reports whether the request comes from the dashboard's
+// htmx history panel rather than an API client.
+func wantsHistoryPanel(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && !wantsJSON(r)
+}
+
+// renderLinkHistory renders the dashboard's history panel for a link, with
+// a notice or the error of the rollback that led to it, if any.
+func (h *Handlers) renderLinkHistory(w http.ResponseWriter, r *http.Request, code, notice string, actionErr error) {
+	data := struct {
+		ShortCode   string
+		Link        *models.LinkMapping
+		Versions    []models.LinkVersion
+		CanRollBack bool
+		Notice      string
+		Error       string
+	}{
+		ShortCode: code,
+		Notice:    notice,
+	}
+
+	if actionErr != nil {
+		data.Error = historyErrorMessage(actionErr)
+	}
+
+	link, err := h.shortenerService.GetLink(code)
+	if err == nil {
+		data.Link = link
+		data.Versions, err = h.shortenerService.LinkVersions(code)
+	}
+	if err != nil {
+		data.Error = historyErrorMessage(err)
+	}
+	if link != nil {
+		principal := authmiddleware.PrincipalFromContext(r.Context())
+		data.CanRollBack = principal != nil && principal.HasScope(models.ScopeLinksWrite) && principal.CanModify(link) && link.DeletedAt == nil
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := h.templates.ExecuteTemplate(w, "link-history.html", data); err != nil {
+		logger.Error("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+func historyErrorMessage(err error) string {
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		return reqErr.message
+	case errors.Is(err, services.ErrLinkNotFound):
+		return "Short code not found"
+	case errors.Is(err, services.ErrForbidden), errors.Is(err, services.ErrVersionNotFound),
+		errors.Is(err, services.ErrInvalidURL), errors.Is(err, services.ErrLinkDeleted):
+		return err.Error()
+	default:
+		logger.Error("Link history error: %v", err)
+		return "Internal server error"
+	}
+}
@@ -0,0 +1,102 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+)
+
+// SyncPolicy controls when appended events are fsynced to disk.
+type SyncPolicy int
+
+const (
+	// SyncInterval fsyncs the active segment every Config.SyncInterval.
+	SyncInterval SyncPolicy = iota
+	// SyncAlways fsyncs after every append.
+	SyncAlways
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+const (
+	defaultSegmentBytes = 16 << 20
+	defaultMaxAttempts  = 5
+)
+
+// Config describes where the journal lives and how it is flushed.
+type Config struct {
+	Dir          string
+	SegmentBytes int64
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+
+	// BatchSize is the maximum number of events applied per transaction.
+	BatchSize int
+	// FlushInterval is how often the consumer applies pending events.
+	FlushInterval time.Duration
+	// NotifyEvery wakes the consumer early after this many appends.
+	NotifyEvery int
+	// MaxAttempts is how many times the consumer tries a failing batch
+	// before it applies the events one at a time and sets aside those
+	// that still fail.
+	MaxAttempts int
+}
+
+// ConfigFromEnv reads the journal configuration from CLICK_JOURNAL_* and
+// CLICK_FLUSH_* environment variables. The journal defaults to a
+// click_journal directory next to the SQLite database; with PostgreSQL,
+// where several instances may share a host, CLICK_JOURNAL_DIR has to be
+// set for each of them.
+func ConfigFromEnv() Config {
+	dir := os.Getenv("CLICK_JOURNAL_DIR")
+	if dir == "" {
+		dbPath := os.Getenv("DATABASE_PATH")
+		if dbPath == "" {
+			dbPath = "link_shortener.db"
+		}
+		dir = filepath.Join(filepath.Dir(dbPath), "click_journal")
+	}
+
+	cfg := Config{
+		Dir:           dir,
+		SegmentBytes:  int64(envInt("CLICK_JOURNAL_SEGMENT_BYTES", defaultSegmentBytes)),
+		Sync:          SyncInterval,
+		SyncInterval:  envDuration("CLICK_JOURNAL_SYNC_INTERVAL", time.Second),
+		BatchSize:     envInt("CLICK_FLUSH_BATCH_SIZE", 5000),
+		FlushInterval: envDuration("CLICK_FLUSH_INTERVAL", 5*time.Minute),
+		MaxAttempts:   envInt("CLICK_FLUSH_MAX_ATTEMPTS", defaultMaxAttempts),
+	}
+	cfg.NotifyEvery = cfg.BatchSize
+
+	switch strings.ToLower(os.Getenv("CLICK_JOURNAL_SYNC")) {
+	case "", "interval":
+	case "always":
+		cfg.Sync = SyncAlways
+	case "never":
+		cfg.Sync = SyncNever
+	default:
+		logger.Warn("Unknown CLICK_JOURNAL_SYNC %q, using interval", os.Getenv("CLICK_JOURNAL_SYNC"))
+	}
+
+	return cfg
+}
+
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,147 @@
+package journal
+
+import (
+	"context"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+)
+
+// ApplyFunc writes a batch of events to durable storage. It must be
+// idempotent on Event.ID because a batch may be replayed after a crash.
+type ApplyFunc func(events []Event) error
+
+// Consumer replays journaled events through an ApplyFunc and advances the
+// journal checkpoint after each successful batch.
+type Consumer struct {
+	journal *Journal
+	apply   ApplyFunc
+
+	batchSize   int
+	interval    time.Duration
+	maxAttempts int
+
+	// failedAt is where the batch that last failed starts, and failures
+	// how many times in a row it has failed.
+	failedAt Checkpoint
+	failures int
+}
+
+// NewConsumer creates a consumer using the batch size and flush interval
+// from the journal's configuration.
+func NewConsumer(j *Journal, apply ApplyFunc) *Consumer {
+	batchSize := j.cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 5000
+	}
+	interval := j.cfg.FlushInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	maxAttempts := j.cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Consumer{
+		journal:     j,
+		apply:       apply,
+		batchSize:   batchSize,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Run replays anything left over from a previous run, then applies new
+// events every flush interval (or sooner once a full batch is waiting)
+// until ctx is cancelled. It does not perform a final flush; call Drain
+// after Run returns.
+func (c *Consumer) Run(ctx context.Context) {
+	if err := c.Drain(); err != nil {
+		logger.Error("Failed to replay click journal: %v", err)
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.journal.Ready():
+		}
+
+		if err := c.Drain(); err != nil {
+			// Leave the checkpoint where it is; the batch is retried next
+			// tick, up to the configured number of attempts
+			logger.Error("Failed to apply click journal batch: %v", err)
+		}
+	}
+}
+
+// Drain applies every event currently in the journal.
+func (c *Consumer) Drain() error {
+	cp := c.journal.Checkpoint()
+
+	for {
+		events, next, err := c.journal.ReadBatch(cp, c.batchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(events) > 0 {
+			logger.Debug("Applying %d journaled clicks", len(events))
+			if err := c.applyBatch(cp, events); err != nil {
+				return err
+			}
+		}
+
+		if next != cp {
+			if err := c.journal.Commit(next); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < c.batchSize {
+			return nil
+		}
+		cp = next
+	}
+}
+
+// applyBatch applies the events read from cp. Once the batch there has
+// failed maxAttempts times in a row, its events are applied one at a time
+// instead and those that still fail are set aside, so that a bad event
+// cannot hold up every click behind it.
+func (c *Consumer) applyBatch(cp Checkpoint, events []Event) error {
+	err := c.apply(events)
+	if err == nil {
+		c.failures = 0
+		return nil
+	}
+
+	if c.failures == 0 || c.failedAt != cp {
+		c.failedAt, c.failures = cp, 0
+	}
+	c.failures++
+	if c.failures < c.maxAttempts {
+		return err
+	}
+
+	logger.Warn("Click journal batch at segment %d offset %d failed %d times (%v), applying its %d events one at a time",
+		cp.Segment, cp.Offset, c.failures, err, len(events))
+	var rejected []Event
+	for _, e := range events {
+		if err := c.apply([]Event{e}); err != nil {
+			logger.Error("Setting aside click event %s for '%s': %v", e.ID, e.ShortCode, err)
+			rejected = append(rejected, e)
+		}
+	}
+	if err := c.journal.SetAside(rejected); err != nil {
+		return err
+	}
+
+	c.failures = 0
+	return nil
+}
@@ -0,0 +1,142 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+func TestDrainSetsAsideBadEvents(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, Config{Dir: dir, MaxAttempts: 3})
+	appendTestEvents(t, j, "e", 4)
+
+	// e2 can never be applied; any batch containing it fails as a whole
+	applied := map[string]int{}
+	attempts := 0
+	consumer := NewConsumer(j, func(events []Event) error {
+		attempts++
+		for _, e := range events {
+			if e.ID == "e2" {
+				return errors.New("constraint violation")
+			}
+		}
+		for _, e := range events {
+			applied[e.ID]++
+		}
+		return nil
+	})
+
+	for i := 1; i < 3; i++ {
+		if err := consumer.Drain(); err == nil {
+			t.Fatalf("drain %d succeeded with a bad event in the batch", i)
+		}
+		if len(applied) != 0 || j.Checkpoint() != (Checkpoint{}) {
+			t.Fatalf("drain %d applied %v or moved the checkpoint", i, applied)
+		}
+	}
+
+	if err := consumer.Drain(); err != nil {
+		t.Fatalf("drain after %d attempts: %v", attempts, err)
+	}
+	for _, id := range []string{"e0", "e1", "e3"} {
+		if applied[id] != 1 {
+			t.Errorf("%s applied %d times, want 1", id, applied[id])
+		}
+	}
+	if len(readAll(t, j)) != 0 {
+		t.Error("checkpoint did not move past the set aside batch")
+	}
+
+	f, err := os.Open(filepath.Join(dir, rejectedFile))
+	if err != nil {
+		t.Fatalf("open rejected events: %v", err)
+	}
+	defer f.Close()
+	var rejected []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("rejected event %q: %v", scanner.Text(), err)
+		}
+		rejected = append(rejected, e.ID)
+	}
+	if len(rejected) != 1 || rejected[0] != "e2" {
+		t.Errorf("set aside %v, want [e2]", rejected)
+	}
+
+	// Later batches are applied normally again
+	appendTestEvents(t, j, "later", 1)
+	if err := consumer.Drain(); err != nil || applied["later0"] != 1 {
+		t.Errorf("drain after setting aside = %v, applied later0 %d times", err, applied["later0"])
+	}
+}
+
+func TestReplayedBatchIsAppliedOnce(t *testing.T) {
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store := storage.NewSQLStore(db)
+	if err := store.CreateLink(&models.LinkMapping{ShortCode: "abc", OriginalURL: "https://example.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+
+	apply := func(events []Event) error {
+		clicks := make([]models.ClickAnalytics, 0, len(events))
+		for _, e := range events {
+			clicks = append(clicks, models.ClickAnalytics{EventID: e.ID, ShortCode: e.ShortCode, Timestamp: e.Timestamp})
+		}
+		return store.RecordClicks(clicks)
+	}
+
+	dir := t.TempDir()
+	j, err := Open(Config{Dir: dir, Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	defer j.Close() // the crashed process's handle
+	appendTestEvents(t, j, "e", 3)
+
+	// Applied, but the crash comes before the checkpoint is committed
+	events, _, err := j.ReadBatch(j.Checkpoint(), 10)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := apply(events); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	j = openTestJournal(t, Config{Dir: dir})
+	if err := NewConsumer(j, apply).Drain(); err != nil {
+		t.Fatalf("drain after restart: %v", err)
+	}
+
+	link, err := store.GetLink("abc")
+	if err != nil {
+		t.Fatalf("get link: %v", err)
+	}
+	if link.ClickCount != 3 {
+		t.Errorf("click_count = %d after replaying the batch, want 3", link.ClickCount)
+	}
+	clicks, err := store.RecentClicks(10, nil)
+	if err != nil {
+		t.Fatalf("recent clicks: %v", err)
+	}
+	if len(clicks) != 3 {
+		t.Errorf("stored %d clicks after replaying the batch, want 3", len(clicks))
+	}
+}
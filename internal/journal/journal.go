@@ -0,0 +1,565 @@
+// Package journal implements an append-only on-disk log of click events.
+//
+// Redirects append events to the active segment file; a Consumer replays
+// them into the database in batches and records how far it got in a
+// checkpoint file. Events are delivered at least once: after a crash the
+// consumer resumes from the last checkpoint, so the applier must be
+// idempotent on Event.ID.
+package journal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/google/uuid"
+)
+
+// Event is a single click recorded in the journal.
+type Event struct {
+	ID        string    `json:"id"`
+	ShortCode string    `json:"short_code"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Counted is set when the redirect already added this click to the
+	// link's click count.
+	Counted bool `json:"counted,omitempty"`
+	// Reason is set when the visit was not redirected to the original URL
+	// because the link is inactive.
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewEventID returns a random identifier used to de-duplicate replayed events.
+func NewEventID() string {
+	return uuid.NewString()
+}
+
+// ErrClosed is returned by Append after the journal has been closed.
+var ErrClosed = errors.New("journal closed")
+
+const (
+	segmentSuffix  = ".log"
+	checkpointFile = "checkpoint"
+	// rejectedFile keeps events the consumer gave up on, one JSON
+	// object per line.
+	rejectedFile = "rejected.jsonl"
+
+	// Each record is a 4-byte payload length and a 4-byte CRC-32C of the
+	// payload, followed by the JSON-encoded event.
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checkpoint is the position of the first event not yet applied.
+type Checkpoint struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Journal is a directory of numbered segment files plus a checkpoint.
+type Journal struct {
+	cfg Config
+
+	mu         sync.Mutex
+	active     *os.File
+	activeSeq  uint64
+	activeSize int64
+	dirty      bool
+	closed     bool
+	checkpoint Checkpoint
+	pending    int
+	notify     chan struct{}
+
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+// Open opens or creates the journal in cfg.Dir. A torn record left at the end
+// of the newest segment by a crash is truncated before appending resumes.
+func Open(cfg Config) (*Journal, error) {
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	j := &Journal{
+		cfg:      cfg,
+		notify:   make(chan struct{}, 1),
+		stopSync: make(chan struct{}),
+		syncDone: make(chan struct{}),
+	}
+
+	cp, err := j.loadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	j.checkpoint = cp
+
+	segments, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	seq := cp.Segment
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		if err := j.recoverSegment(last); err != nil {
+			return nil, err
+		}
+		if last >= seq {
+			seq = last
+		}
+	}
+
+	// Always start appending to a fresh segment so recovered data is never
+	// interleaved with new writes.
+	if err := j.openSegment(seq + 1); err != nil {
+		return nil, err
+	}
+
+	if cfg.Sync == SyncInterval {
+		go j.syncLoop()
+	} else {
+		close(j.syncDone)
+	}
+
+	return j, nil
+}
+
+// Append writes an event to the active segment, rotating it when full.
+func (j *Journal) Append(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode click event: %w", err)
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("click event too large: %d bytes", len(payload))
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[recordHeaderSize:], payload)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return ErrClosed
+	}
+
+	if j.activeSize > 0 && j.activeSize+int64(len(record)) > j.cfg.SegmentBytes {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := j.active.Write(record); err != nil {
+		// Drop any partial record so later appends stay aligned
+		j.active.Truncate(j.activeSize)
+		return fmt.Errorf("failed to append click event: %w", err)
+	}
+	j.activeSize += int64(len(record))
+
+	switch j.cfg.Sync {
+	case SyncAlways:
+		if err := j.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	case SyncInterval:
+		j.dirty = true
+	}
+
+	j.pending++
+	if j.cfg.NotifyEvery > 0 && j.pending >= j.cfg.NotifyEvery {
+		j.pending = 0
+		select {
+		case j.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Ready is signalled whenever Config.NotifyEvery events have been appended,
+// letting a consumer flush before its timer fires.
+func (j *Journal) Ready() <-chan struct{} {
+	return j.notify
+}
+
+// Checkpoint returns the last committed read position.
+func (j *Journal) Checkpoint() Checkpoint {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.checkpoint
+}
+
+// ReadBatch returns up to max events starting at from, along with the
+// position just after the last event returned.
+func (j *Journal) ReadBatch(from Checkpoint, max int) ([]Event, Checkpoint, error) {
+	j.mu.Lock()
+	activeSeq, activeSize := j.activeSeq, j.activeSize
+	j.mu.Unlock()
+
+	segments, err := j.segments()
+	if err != nil {
+		return nil, from, err
+	}
+
+	var events []Event
+	pos := from
+
+	for _, seq := range segments {
+		if seq < pos.Segment {
+			continue
+		}
+		if seq > pos.Segment {
+			pos = Checkpoint{Segment: seq}
+		}
+
+		// Only read what Append has fully written to the active segment
+		limit := int64(-1)
+		if seq == activeSeq {
+			limit = activeSize
+		}
+
+		read, next, complete, err := j.readSegment(seq, pos.Offset, limit, max-len(events))
+		if err != nil {
+			return nil, from, err
+		}
+		events = append(events, read...)
+		pos.Offset = next
+
+		if len(events) >= max || seq == activeSeq {
+			break
+		}
+		if !complete {
+			// Sealed segments only end early if they were damaged on disk;
+			// skip the unreadable remainder rather than stalling forever.
+			logger.Warn("Skipping unreadable data in click journal segment %d after offset %d", seq, next)
+		}
+	}
+
+	return events, pos, nil
+}
+
+// Commit records cp as applied and removes segments that precede it.
+func (j *Journal) Commit(cp Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(j.cfg.Dir, checkpointFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("failed to write journal checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(j.cfg.Dir, checkpointFile)); err != nil {
+		return fmt.Errorf("failed to write journal checkpoint: %w", err)
+	}
+	syncDir(j.cfg.Dir)
+
+	j.mu.Lock()
+	j.checkpoint = cp
+	activeSeq := j.activeSeq
+	j.mu.Unlock()
+
+	segments, err := j.segments()
+	if err != nil {
+		return err
+	}
+	for _, seq := range segments {
+		if seq >= cp.Segment || seq == activeSeq {
+			break
+		}
+		if err := os.Remove(j.segmentPath(seq)); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove applied journal segment %d: %v", seq, err)
+		}
+	}
+
+	return nil
+}
+
+// SetAside appends events that could not be applied to the rejected.jsonl
+// file in the journal directory, where they are kept for inspection.
+func (j *Journal) SetAside(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var data []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode click event: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	f, err := os.OpenFile(filepath.Join(j.cfg.Dir, rejectedFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to set aside click events: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set aside click events: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set aside click events: %w", err)
+	}
+	return f.Close()
+}
+
+// Close syncs and closes the active segment. Further appends fail with ErrClosed.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	j.mu.Unlock()
+
+	close(j.stopSync)
+	<-j.syncDone
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.cfg.Sync != SyncNever {
+		if err := j.active.Sync(); err != nil {
+			j.active.Close()
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+	return j.active.Close()
+}
+
+func (j *Journal) syncLoop() {
+	defer close(j.syncDone)
+
+	ticker := time.NewTicker(j.cfg.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stopSync:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty && !j.closed {
+				if err := j.active.Sync(); err != nil {
+					logger.Error("Failed to sync click journal: %v", err)
+				}
+				j.dirty = false
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// rotate seals the active segment and starts the next one. Callers hold j.mu.
+func (j *Journal) rotate() error {
+	if j.cfg.Sync != SyncNever {
+		if err := j.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal segment: %w", err)
+		}
+	}
+	if err := j.active.Close(); err != nil {
+		return fmt.Errorf("failed to close journal segment: %w", err)
+	}
+	return j.openSegment(j.activeSeq + 1)
+}
+
+func (j *Journal) openSegment(seq uint64) error {
+	f, err := os.OpenFile(j.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat journal segment: %w", err)
+	}
+	syncDir(j.cfg.Dir)
+
+	j.active = f
+	j.activeSeq = seq
+	j.activeSize = info.Size()
+	j.dirty = false
+	return nil
+}
+
+// recoverSegment truncates a segment after its last intact record.
+func (j *Journal) recoverSegment(seq uint64) error {
+	_, valid, complete, err := j.readSegment(seq, 0, -1, -1)
+	if err != nil {
+		return err
+	}
+	if complete {
+		return nil
+	}
+
+	logger.Warn("Click journal segment %d has a torn tail, truncating to %d bytes", seq, valid)
+	if err := os.Truncate(j.segmentPath(seq), valid); err != nil {
+		return fmt.Errorf("failed to truncate journal segment: %w", err)
+	}
+	return nil
+}
+
+// readSegment decodes records from offset until limit bytes (or EOF when
+// limit is negative), returning at most max events (unlimited when max is
+// negative). It reports the offset after the last good record and whether
+// the segment ended cleanly.
+func (j *Journal) readSegment(seq uint64, offset, limit int64, max int) ([]Event, int64, bool, error) {
+	f, err := os.Open(j.segmentPath(seq))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, offset, true, nil
+		}
+		return nil, offset, false, fmt.Errorf("failed to open journal segment: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, false, fmt.Errorf("failed to seek journal segment: %w", err)
+	}
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit-offset)
+	}
+
+	var events []Event
+	header := make([]byte, recordHeaderSize)
+	pos := offset
+
+	for max < 0 || len(events) < max {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return events, pos, true, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return events, pos, false, nil
+			}
+			return nil, offset, false, fmt.Errorf("failed to read journal segment: %w", err)
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if size > maxRecordSize {
+			return events, pos, false, nil
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return events, pos, false, nil
+			}
+			return nil, offset, false, fmt.Errorf("failed to read journal segment: %w", err)
+		}
+		if crc32.Checksum(payload, crcTable) != sum {
+			return events, pos, false, nil
+		}
+
+		var e Event
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return events, pos, false, nil
+		}
+
+		events = append(events, e)
+		pos += int64(recordHeaderSize) + int64(size)
+	}
+
+	return events, pos, true, nil
+}
+
+// segments lists segment sequence numbers in ascending order.
+func (j *Journal) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(j.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list journal segments: %w", err)
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(a, b int) bool { return seqs[a] < seqs[b] })
+	return seqs, nil
+}
+
+func (j *Journal) segmentPath(seq uint64) string {
+	return filepath.Join(j.cfg.Dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+func (j *Journal) loadCheckpoint() (Checkpoint, error) {
+	var cp Checkpoint
+
+	data, err := os.ReadFile(filepath.Join(j.cfg.Dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("failed to read journal checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("corrupt journal checkpoint: %w", err)
+	}
+
+	return cp, nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes file creations and renames in dir durable.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
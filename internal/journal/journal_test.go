@@ -0,0 +1,188 @@
+package journal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestJournal(t *testing.T, cfg Config) *Journal {
+	t.Helper()
+	cfg.Sync = SyncNever
+	j, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func appendTestEvents(t *testing.T, j *Journal, prefix string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := j.Append(Event{ID: fmt.Sprintf("%s%d", prefix, i), ShortCode: "abc", Timestamp: time.Unix(1_700_000_000, 0)})
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+}
+
+// readAll returns every event from the checkpoint on.
+func readAll(t *testing.T, j *Journal) []Event {
+	t.Helper()
+	events, _, err := j.ReadBatch(j.Checkpoint(), 1000)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return events
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatalf("list segments: %v", err)
+	}
+	return files
+}
+
+func TestOpenTruncatesDamagedTail(t *testing.T) {
+	tests := []struct {
+		name string
+		// tail builds what a crash left after the last intact record
+		tail func() []byte
+	}{
+		{"torn header", func() []byte { return []byte{42, 0, 0} }},
+		{"torn payload", func() []byte {
+			tail := make([]byte, recordHeaderSize+5)
+			binary.LittleEndian.PutUint32(tail[0:4], 100)
+			return tail
+		}},
+		{"bad checksum", func() []byte {
+			payload := []byte(`{"id":"forged","short_code":"abc"}`)
+			tail := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+			binary.LittleEndian.PutUint32(tail[0:4], uint32(len(payload)))
+			binary.LittleEndian.PutUint32(tail[4:8], 12345)
+			return append(tail, payload...)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			j, err := Open(Config{Dir: dir, Sync: SyncNever})
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			appendTestEvents(t, j, "e", 3)
+			j.Close()
+
+			files := segmentFiles(t, dir)
+			last := files[len(files)-1]
+			info, err := os.Stat(last)
+			if err != nil {
+				t.Fatalf("stat: %v", err)
+			}
+			f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatalf("open segment: %v", err)
+			}
+			f.Write(tt.tail())
+			f.Close()
+
+			j = openTestJournal(t, Config{Dir: dir})
+			if after, _ := os.Stat(last); after.Size() != info.Size() {
+				t.Errorf("segment is %d bytes after recovery, want %d", after.Size(), info.Size())
+			}
+
+			// New appends go to a fresh segment after the recovered one
+			appendTestEvents(t, j, "new", 1)
+			events := readAll(t, j)
+			if len(events) != 4 || events[2].ID != "e2" || events[3].ID != "new0" {
+				t.Errorf("read %d events after recovery, want e0-e2 and new0: %+v", len(events), events)
+			}
+		})
+	}
+}
+
+func TestReplayFromCheckpointAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(Config{Dir: dir, Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer j.Close() // the crashed process's handle
+
+	appendTestEvents(t, j, "applied", 3)
+	var applied []Event
+	consumer := NewConsumer(j, func(events []Event) error {
+		applied = append(applied, events...)
+		return nil
+	})
+	if err := consumer.Drain(); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	// These are read and applied, but the process dies before the
+	// checkpoint is committed. The journal is not closed either.
+	appendTestEvents(t, j, "pending", 2)
+	if events, _, err := j.ReadBatch(j.Checkpoint(), 10); err != nil || len(events) != 2 {
+		t.Fatalf("read = %d events, %v; want 2", len(events), err)
+	}
+
+	j = openTestJournal(t, Config{Dir: dir})
+	applied = nil
+	consumer = NewConsumer(j, func(events []Event) error {
+		applied = append(applied, events...)
+		return nil
+	})
+	if err := consumer.Drain(); err != nil {
+		t.Fatalf("drain after restart: %v", err)
+	}
+	if len(applied) != 2 || applied[0].ID != "pending0" || applied[1].ID != "pending1" {
+		t.Errorf("replayed %+v, want only the events after the checkpoint", applied)
+	}
+
+	if err := consumer.Drain(); err != nil {
+		t.Fatalf("second drain: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("second drain replayed %d more events, want none", len(applied)-2)
+	}
+}
+
+func TestSegmentsRotateAndAreRemovedOnceApplied(t *testing.T) {
+	dir := t.TempDir()
+	// Small enough for every event to start a new segment
+	j := openTestJournal(t, Config{Dir: dir, SegmentBytes: 64, BatchSize: 3})
+
+	appendTestEvents(t, j, "e", 7)
+	if n := len(segmentFiles(t, dir)); n < 7 {
+		t.Fatalf("%d segments after 7 oversized appends, want at least 7", n)
+	}
+
+	var applied []Event
+	consumer := NewConsumer(j, func(events []Event) error {
+		applied = append(applied, events...)
+		return nil
+	})
+	if err := consumer.Drain(); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if len(applied) != 7 {
+		t.Fatalf("applied %d events across batches of 3, want 7", len(applied))
+	}
+	for i, e := range applied {
+		if e.ID != fmt.Sprintf("e%d", i) {
+			t.Fatalf("event %d is %s, want events in append order", i, e.ID)
+		}
+	}
+
+	// Only the active segment is left once everything is checkpointed
+	if files := segmentFiles(t, dir); len(files) != 1 {
+		t.Errorf("%d segments left after draining, want 1: %v", len(files), files)
+	}
+}
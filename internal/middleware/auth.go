@@ -1,114 +1,236 @@
 package middleware
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
 )
 
-// AuthMiddleware validates bearer token for API access
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the expected token from environment
-		expectedToken := os.Getenv("AUTH_TOKEN")
-		if expectedToken == "" {
-			http.Error(w, "AUTH_TOKEN environment variable not configured", http.StatusInternalServerError)
-			return
-		}
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	sessionKey
+	clientIPKey
+)
+
+const (
+	// SessionCookieName is the cookie holding a dashboard session token.
+	SessionCookieName = "ls_session"
+	// CSRFHeader carries the session's CSRF token on htmx requests; plain
+	// forms send it as the csrf_token field instead.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// authError writes an authentication or rate limit failure, using the JSON error envelope
+// for API paths and JSON clients and plain text everywhere else. htmx
+// requests whose session has ended are sent to the login page.
+func authError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if status == http.StatusUnauthorized && r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", "/login")
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.ErrorResponse{Error: message, Code: code})
+		return
+	}
+	http.Error(w, message, status)
+}
 
+// KeyAuthenticator resolves a bearer token to the principal it belongs to,
+// returning nil for tokens that are unknown or revoked.
+type KeyAuthenticator interface {
+	AuthenticateKey(token string) (*models.Principal, error)
+}
+
+// SessionAuthenticator resolves a session cookie, returning nil for
+// sessions that are unknown or expired.
+type SessionAuthenticator interface {
+	AuthenticateSession(token string) (*models.Session, error)
+}
+
+// Authenticator checks request credentials.
+type Authenticator struct {
+	keys     KeyAuthenticator
+	sessions SessionAuthenticator
+}
+
+func NewAuthenticator(keys KeyAuthenticator, sessions SessionAuthenticator) *Authenticator {
+	return &Authenticator{keys: keys, sessions: sessions}
+}
+
+// AuthMiddleware requires either a valid API key (or the legacy
+// AUTH_TOKEN) as a bearer token or a dashboard session cookie, and stores
+// the caller's Principal in the request context. Requests authenticated
+// by cookie must also carry the session's CSRF token unless they are
+// read-only.
+func (a *Authenticator) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			session, err := a.session(r)
+			if err != nil {
+				logger.Error("Failed to authenticate session: %v", err)
+				authError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+				return
+			}
+			if session == nil {
+				authError(w, r, http.StatusUnauthorized, "unauthorized", "Authorization header required")
+				return
+			}
+			if !validCSRF(r, session) {
+				authError(w, r, http.StatusForbidden, "invalid_csrf_token", "Missing or invalid CSRF token")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), session)))
 			return
 		}
 
 		// Check if it starts with "Bearer "
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Bearer token required", http.StatusUnauthorized)
+			authError(w, r, http.StatusUnauthorized, "unauthorized", "Bearer token required")
 			return
 		}
 
 		// Extract the token
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != expectedToken {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		principal, err := a.keys.AuthenticateKey(token)
+		if err != nil {
+			logger.Error("Failed to authenticate API key: %v", err)
+			authError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		if principal == nil {
+			authError(w, r, http.StatusUnauthorized, "unauthorized", "Invalid token")
 			return
 		}
 
 		// Token is valid, continue to next handler
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 	})
 }
 
-// OptionalAuthMiddleware validates bearer token but allows requests without it for public endpoints
-func OptionalAuthMiddleware(next http.Handler) http.Handler {
+// RequireLogin protects dashboard pages: browsers without a valid session
+// are redirected to the login page, and state-changing requests must carry
+// the session's CSRF token.
+func (a *Authenticator) RequireLogin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the expected token from environment
-		expectedToken := os.Getenv("AUTH_TOKEN")
-		if expectedToken == "" {
-			http.Error(w, "AUTH_TOKEN environment variable not configured", http.StatusInternalServerError)
+		session, err := a.session(r)
+		if err != nil {
+			logger.Error("Failed to authenticate session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-
-		// Get Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			// If header is present, validate it
-			if !strings.HasPrefix(authHeader, "Bearer ") {
-				http.Error(w, "Bearer token required", http.StatusUnauthorized)
-				return
-			}
-
-			token := strings.TrimPrefix(authHeader, "Bearer ")
-			if token != expectedToken {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+		if session == nil {
+			if r.Method != http.MethodGet {
+				authError(w, r, http.StatusUnauthorized, "unauthorized", "Login required")
 				return
 			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		if !validCSRF(r, session) {
+			authError(w, r, http.StatusForbidden, "invalid_csrf_token", "Missing or invalid CSRF token")
+			return
 		}
 
-		// Either no auth header (public access) or valid token
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), session)))
 	})
 }
 
-// ConditionalAuthMiddleware requires auth only for JSON API requests, not form submissions
-func ConditionalAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if this is a JSON API request
-		isJSONRequest := strings.Contains(r.Header.Get("Accept"), "application/json") ||
-			strings.Contains(r.Header.Get("Content-Type"), "application/json")
+// session returns the request's dashboard session, or nil if it has none.
+func (a *Authenticator) session(r *http.Request) (*models.Session, error) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	return a.sessions.AuthenticateSession(cookie.Value)
+}
+
+// validCSRF reports whether a cookie-authenticated request may proceed.
+// Safe methods need no token.
+func validCSRF(r *http.Request, session *models.Session) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.PostFormValue("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// RequireScope rejects requests whose principal lacks scope. It must run
+// after AuthMiddleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := PrincipalFromContext(r.Context())
+			if principal == nil {
+				authError(w, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
+			if !principal.HasScope(scope) {
+				authError(w, r, http.StatusForbidden, "insufficient_scope", "This key lacks the "+scope+" scope")
+				return
+			}
 
-		// If it's not a JSON request (i.e., form submission), allow it through
-		if !isJSONRequest {
 			next.ServeHTTP(w, r)
-			return
-		}
+		})
+	}
+}
 
-		// For JSON requests, require authentication
-		expectedToken := os.Getenv("AUTH_TOKEN")
-		if expectedToken == "" {
-			http.Error(w, "AUTH_TOKEN environment variable not configured", http.StatusInternalServerError)
-			return
-		}
+// RequireRole rejects requests whose principal's role is below min. It
+// must run after AuthMiddleware.
+func RequireRole(min models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := PrincipalFromContext(r.Context())
+			if principal == nil {
+				authError(w, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
+			if !principal.Role.AtLeast(min) {
+				authError(w, r, http.StatusForbidden, "insufficient_role", "This requires the "+string(min)+" role")
+				return
+			}
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required for API requests", http.StatusUnauthorized)
-			return
-		}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Bearer token required", http.StatusUnauthorized)
-			return
-		}
+// WithPrincipal returns a copy of ctx carrying the authenticated caller.
+func WithPrincipal(ctx context.Context, principal *models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != expectedToken {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+// PrincipalFromContext returns the caller stored by AuthMiddleware, or nil.
+func PrincipalFromContext(ctx context.Context) *models.Principal {
+	principal, _ := ctx.Value(principalKey).(*models.Principal)
+	return principal
+}
 
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
+// WithSession returns a copy of ctx carrying a dashboard session and the
+// principal it authenticates.
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return WithPrincipal(context.WithValue(ctx, sessionKey, session), session.Principal())
+}
+
+// SessionFromContext returns the session stored by RequireLogin or
+// AuthMiddleware, or nil for requests made with an API key.
+func SessionFromContext(ctx context.Context) *models.Session {
+	session, _ := ctx.Value(sessionKey).(*models.Session)
+	return session
+}
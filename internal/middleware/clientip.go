@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// defaultTrustedProxies trusts a reverse proxy on the same host, which is
+// how the service is deployed behind nginx.
+const defaultTrustedProxies = "127.0.0.0/8,::1/128"
+
+// TrustedProxiesFromEnv reads the proxies whose forwarding headers are
+// believed: the comma-separated addresses or CIDR ranges in
+// TRUSTED_PROXIES (default loopback only; set it to "none" to trust no
+// proxy) plus one range per line from TRUSTED_PROXIES_FILE, such as
+// Cloudflare's published ranges.
+func TrustedProxiesFromEnv() ([]netip.Prefix, error) {
+	value, ok := os.LookupEnv("TRUSTED_PROXIES")
+	if !ok {
+		value = defaultTrustedProxies
+	}
+
+	var proxies []netip.Prefix
+	if value != "none" {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			prefix, err := parsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+			}
+			proxies = append(proxies, prefix)
+		}
+	}
+
+	if path := os.Getenv("TRUSTED_PROXIES_FILE"); path != "" {
+		fromFile, err := readPrefixFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES_FILE: %w", err)
+		}
+		proxies = append(proxies, fromFile...)
+	}
+
+	return proxies, nil
+}
+
+// readPrefixFile reads one address or range per line, skipping blank
+// lines and # comments.
+func readPrefixFile(path string) ([]netip.Prefix, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, scanner.Err()
+}
+
+// parsePrefix accepts a CIDR range or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IPResolver works out the client address of requests that may have
+// passed through trusted reverse proxies.
+type IPResolver struct {
+	trusted []netip.Prefix
+}
+
+func NewIPResolver(trusted []netip.Prefix) *IPResolver {
+	return &IPResolver{trusted: trusted}
+}
+
+// Resolve stores the client address of each request for ClientIP and
+// sets RemoteAddr to it, so that request logs show the client too.
+func (res *IPResolver) Resolve(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := res.ClientIP(r)
+		if ip.IsValid() {
+			r.RemoteAddr = ip.String()
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey, ip))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the client address of r. Forwarding headers are only
+// believed when the connection comes from a trusted proxy: X-Forwarded-For
+// is walked from the right, skipping trusted proxies, to the first address
+// a trusted proxy saw. When every hop is a trusted proxy, CF-Connecting-IP
+// and then X-Real-IP name the client.
+func (res *IPResolver) ClientIP(r *http.Request) netip.Addr {
+	ip := parseRemoteAddr(r.RemoteAddr)
+	if !ip.IsValid() || !res.isTrusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(hops[i])
+		if entry == "" {
+			continue
+		}
+		hop, err := netip.ParseAddr(entry)
+		if err != nil {
+			// A trusted proxy forwarded something unusable; the proxy
+			// itself is the last address known to be genuine
+			return ip
+		}
+		ip = hop.WithZone("").Unmap()
+		if !res.isTrusted(ip) {
+			return ip
+		}
+	}
+
+	for _, header := range []string{"CF-Connecting-IP", "X-Real-IP"} {
+		if value := strings.TrimSpace(r.Header.Get(header)); value != "" {
+			if addr, err := netip.ParseAddr(value); err == nil {
+				return addr.WithZone("").Unmap()
+			}
+		}
+	}
+
+	return ip
+}
+
+func (res *IPResolver) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRemoteAddr parses an http.Request RemoteAddr, which is "host:port"
+// with IPv6 hosts in brackets, or a bare address once Resolve has run.
+func parseRemoteAddr(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	// Zones such as fe80::1%eth0 identify an interface, not a client
+	return addr.WithZone("").Unmap()
+}
+
+// ClientIP returns the address of the client that sent r, as resolved by
+// IPResolver.Resolve, or the connection's address when Resolve did not
+// run. It is empty if neither is known.
+func ClientIP(r *http.Request) string {
+	if ip := clientAddr(r); ip.IsValid() {
+		return ip.String()
+	}
+	return ""
+}
+
+// clientAddr is ClientIP as an address, invalid if it is not known.
+func clientAddr(r *http.Request) netip.Addr {
+	if ip, ok := r.Context().Value(clientIPKey).(netip.Addr); ok {
+		return ip
+	}
+	return parseRemoteAddr(r.RemoteAddr)
+}
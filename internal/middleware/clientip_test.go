@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPResolverClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:5000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "forwarding headers from an untrusted peer are ignored",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "CF-Connecting-IP from an untrusted peer is ignored",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"CF-Connecting-IP": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "X-Real-IP from an untrusted peer is ignored",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "client behind a trusted proxy",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed leftmost entry",
+			remoteAddr: "127.0.0.1:5000",
+			// The client sent its own X-Forwarded-For; nginx appended
+			// the address it really connected from
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:       "trusted proxies along the chain are skipped",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.1.2.3, 10.4.5.6"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "all-trusted chain falls back to CF-Connecting-IP",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "10.1.2.3, 10.4.5.6", "CF-Connecting-IP": "203.0.113.7", "X-Real-IP": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "all-trusted chain falls back to X-Real-IP",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "10.1.2.3", "X-Real-IP": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "all-trusted chain without other headers",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "10.1.2.3, 10.4.5.6"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "unparseable hop stops at the proxy",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7, garbage, 10.1.2.3"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "IPv6 client behind an IPv6 proxy",
+			remoteAddr: "[2001:db8:ffff::1]:5000",
+			headers:    map[string]string{"X-Forwarded-For": "2001:db8:1::7"},
+			want:       "2001:db8:1::7",
+		},
+		{
+			name:       "IPv4-mapped addresses are unmapped",
+			remoteAddr: "[::ffff:127.0.0.1]:5000",
+			headers:    map[string]string{"X-Forwarded-For": "::ffff:203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "zoned peer",
+			remoteAddr: "[fe80::1%eth0]:5000",
+			want:       "fe80::1",
+		},
+		{
+			name:       "zoned forwarded address",
+			remoteAddr: "[::1]:5000",
+			headers:    map[string]string{"X-Forwarded-For": "fe80::7%eth0"},
+			want:       "fe80::7",
+		},
+		{
+			name:       "zoned CF-Connecting-IP",
+			remoteAddr: "[::1]:5000",
+			headers:    map[string]string{"CF-Connecting-IP": "fe80::7%eth0"},
+			want:       "fe80::7",
+		},
+	}
+
+	res := NewIPResolver(trusted)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			if got := res.ClientIP(req).String(); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSetsClientIP(t *testing.T) {
+	res := NewIPResolver([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+
+	var got, remoteAddr string
+	handler := res.Resolve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, remoteAddr = ClientIP(r), r.RemoteAddr
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "203.0.113.7" || remoteAddr != "203.0.113.7" {
+		t.Errorf("after Resolve: ClientIP = %q, RemoteAddr = %q; want the forwarded client in both", got, remoteAddr)
+	}
+}
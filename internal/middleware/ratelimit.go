@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitMaxKeys = 100000
+
+// RateLimitConfig sets a token bucket: each key may make Burst requests at
+// once, refilled at PerMinute requests a minute.
+type RateLimitConfig struct {
+	// PerMinute is the sustained rate; zero disables the limit.
+	PerMinute int
+	Burst     int
+	// MaxKeys bounds memory: beyond it the least recently used buckets are
+	// dropped, which only ever lets their keys start over with a full
+	// bucket.
+	MaxKeys int
+}
+
+// RateLimitConfigFromEnv reads RATE_LIMIT_<name>_PER_MINUTE and
+// RATE_LIMIT_<name>_BURST, falling back to the given defaults, and the
+// shared RATE_LIMIT_MAX_KEYS.
+func RateLimitConfigFromEnv(name string, perMinute, burst int) RateLimitConfig {
+	cfg := RateLimitConfig{
+		PerMinute: envInt("RATE_LIMIT_"+name+"_PER_MINUTE", perMinute),
+		Burst:     envInt("RATE_LIMIT_"+name+"_BURST", burst),
+		MaxKeys:   envInt("RATE_LIMIT_MAX_KEYS", defaultRateLimitMaxKeys),
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.MaxKeys < 1 {
+		cfg.MaxKeys = defaultRateLimitMaxKeys
+	}
+	return cfg
+}
+
+// envInt accepts zero so that operators can turn a limit off explicitly.
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter keeps a token bucket per key. It is safe for concurrent use.
+type RateLimiter struct {
+	cfg  RateLimitConfig
+	rate float64 // tokens per second
+
+	mu      sync.Mutex
+	ll      *list.List
+	buckets map[string]*list.Element
+}
+
+// NewRateLimiter creates a limiter. A PerMinute of zero allows everything.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:     cfg,
+		rate:    float64(cfg.PerMinute) / 60,
+		ll:      list.New(),
+		buckets: make(map[string]*list.Element),
+	}
+}
+
+// rateLimitResult describes the bucket after a request was counted.
+type rateLimitResult struct {
+	allowed   bool
+	remaining int
+	// reset is how long until the bucket is full again.
+	reset time.Duration
+	// retryAfter is how long until the next request would be allowed.
+	retryAfter time.Duration
+}
+
+// take spends a token from key's bucket if it has one.
+func (l *RateLimiter) take(key string, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(l.cfg.Burst)
+	var b *bucket
+	if el, ok := l.buckets[key]; ok {
+		l.ll.MoveToFront(el)
+		b = el.Value.(*bucket)
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+		b.updated = now
+	} else {
+		b = &bucket{key: key, tokens: burst, updated: now}
+		l.buckets[key] = l.ll.PushFront(b)
+		for l.ll.Len() > l.cfg.MaxKeys {
+			oldest := l.ll.Back()
+			l.ll.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*bucket).key)
+		}
+	}
+
+	result := rateLimitResult{allowed: b.tokens >= 1}
+	if result.allowed {
+		b.tokens--
+	} else {
+		result.retryAfter = l.refillTime(1 - b.tokens)
+	}
+	result.remaining = int(b.tokens)
+	result.reset = l.refillTime(burst - b.tokens)
+	return result
+}
+
+func (l *RateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// Limit returns middleware that counts each request against the bucket
+// keyFunc picks for it. Responses carry RateLimit-Limit, -Remaining and
+// -Reset headers; requests over the limit get 429 with Retry-After.
+func (l *RateLimiter) Limit(keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l.cfg.PerMinute == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result := l.take(keyFunc(r), time.Now())
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(l.cfg.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+
+			if !result.allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+				authError(w, r, http.StatusTooManyRequests, "rate_limited", "Too many requests, please retry later")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ByClientIP keys rate limits by the client's address. IPv6 clients are
+// keyed by their /64, which a single host or subscriber usually has to
+// itself, so that moving around in it does not escape the limit.
+func ByClientIP(r *http.Request) string {
+	ip := clientAddr(r)
+	switch {
+	case !ip.IsValid():
+		return "ip:"
+	case ip.Is6():
+		return "ip:" + netip.PrefixFrom(ip, 64).Masked().String()
+	}
+	return "ip:" + ip.String()
+}
+
+// ByPrincipal keys rate limits by the API key or user making the request,
+// falling back to the client's address. It must run after
+// AuthMiddleware.
+func ByPrincipal(r *http.Request) string {
+	principal := PrincipalFromContext(r.Context())
+	switch {
+	case principal == nil:
+		return ByClientIP(r)
+	case principal.KeyID != 0:
+		return "key:" + strconv.FormatInt(principal.KeyID, 10)
+	case principal.UserID != 0:
+		return "user:" + strconv.FormatInt(principal.UserID, 10)
+	}
+	// The legacy AUTH_TOKEN
+	return "token:" + principal.Name
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+
+	// step is one request to key at start+at, and what it should see
+	type step struct {
+		key        string
+		at         time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name  string
+		cfg   RateLimitConfig
+		steps []step
+	}{
+		{
+			name: "burst then refusal",
+			cfg:  RateLimitConfig{PerMinute: 60, Burst: 3, MaxKeys: 10},
+			steps: []step{
+				{key: "a", allowed: true, remaining: 2, reset: time.Second},
+				{key: "a", allowed: true, remaining: 1, reset: 2 * time.Second},
+				{key: "a", allowed: true, remaining: 0, reset: 3 * time.Second},
+				{key: "a", allowed: false, remaining: 0, reset: 3 * time.Second, retryAfter: time.Second},
+			},
+		},
+		{
+			name: "refill over time",
+			cfg:  RateLimitConfig{PerMinute: 60, Burst: 2, MaxKeys: 10},
+			steps: []step{
+				{key: "a", allowed: true, remaining: 1, reset: time.Second},
+				{key: "a", allowed: true, remaining: 0, reset: 2 * time.Second},
+				{key: "a", at: 500 * time.Millisecond, allowed: false, remaining: 0, reset: 1500 * time.Millisecond, retryAfter: 500 * time.Millisecond},
+				{key: "a", at: time.Second, allowed: true, remaining: 0, reset: 2 * time.Second},
+			},
+		},
+		{
+			name: "refill is capped at the burst",
+			cfg:  RateLimitConfig{PerMinute: 60, Burst: 2, MaxKeys: 10},
+			steps: []step{
+				{key: "a", allowed: true, remaining: 1, reset: time.Second},
+				{key: "a", at: time.Hour, allowed: true, remaining: 1, reset: time.Second},
+				{key: "a", at: time.Hour, allowed: true, remaining: 0, reset: 2 * time.Second},
+				{key: "a", at: time.Hour, allowed: false, remaining: 0, reset: 2 * time.Second, retryAfter: time.Second},
+			},
+		},
+		{
+			name: "keys have their own buckets",
+			cfg:  RateLimitConfig{PerMinute: 60, Burst: 1, MaxKeys: 10},
+			steps: []step{
+				{key: "a", allowed: true, remaining: 0, reset: time.Second},
+				{key: "b", allowed: true, remaining: 0, reset: time.Second},
+				{key: "a", allowed: false, remaining: 0, reset: time.Second, retryAfter: time.Second},
+			},
+		},
+		{
+			name: "least recently used bucket is evicted",
+			cfg:  RateLimitConfig{PerMinute: 60, Burst: 1, MaxKeys: 2},
+			steps: []step{
+				{key: "a", allowed: true, remaining: 0, reset: time.Second},
+				{key: "b", allowed: true, remaining: 0, reset: time.Second},
+				// Using a again makes b the oldest, which c pushes out
+				{key: "a", allowed: false, remaining: 0, reset: time.Second, retryAfter: time.Second},
+				{key: "c", allowed: true, remaining: 0, reset: time.Second},
+				{key: "a", allowed: false, remaining: 0, reset: time.Second, retryAfter: time.Second},
+				{key: "b", allowed: true, remaining: 0, reset: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.cfg)
+			for i, s := range tt.steps {
+				got := l.take(s.key, start.Add(s.at))
+				want := rateLimitResult{allowed: s.allowed, remaining: s.remaining, reset: s.reset, retryAfter: s.retryAfter}
+				if got != want {
+					t.Errorf("request %d (%s at +%s) = %+v, want %+v", i+1, s.key, s.at, got, want)
+				}
+			}
+			if n := len(l.buckets); n > tt.cfg.MaxKeys || n != l.ll.Len() {
+				t.Errorf("%d buckets in the map and %d in the list, want at most %d", n, l.ll.Len(), tt.cfg.MaxKeys)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{PerMinute: 30, Burst: 2, MaxKeys: 10})
+	handler := l.Limit(ByClientIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		// 30 a minute refills a token every 2 seconds
+		{http.StatusNoContent, "1", "2", ""},
+		{http.StatusNoContent, "0", "4", ""},
+		{http.StatusTooManyRequests, "0", "4", "2"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		h := rec.Header()
+		if rec.Code != tt.status || h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != tt.remaining ||
+			h.Get("RateLimit-Reset") != tt.reset || h.Get("Retry-After") != tt.retryAfter {
+			t.Errorf("request %d: %d, limit %q, remaining %q, reset %q, retry after %q; want %d, \"2\", %q, %q, %q",
+				i+1, rec.Code, h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"), h.Get("RateLimit-Reset"), h.Get("Retry-After"),
+				tt.status, tt.remaining, tt.reset, tt.retryAfter)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{PerMinute: 0, Burst: 1, MaxKeys: 10})
+	handler := l.Limit(ByClientIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/abc", nil))
+		if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("request %d with PerMinute 0: %d, RateLimit-Limit %q; want 204 without headers", i+1, rec.Code, rec.Header().Get("RateLimit-Limit"))
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("disabled limiter kept %d buckets", len(l.buckets))
+	}
+}
+
+func TestByClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"192.0.2.1:1234", "ip:192.0.2.1"},
+		{"[::ffff:192.0.2.1]:1234", "ip:192.0.2.1"},
+		{"[2001:db8:1:2:aaaa::1]:1234", "ip:2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:ffff:ffff:ffff:ffff]:1234", "ip:2001:db8:1:2::/64"},
+		{"[2001:db8:1:3::1]:1234", "ip:2001:db8:1:3::/64"},
+		{"[fe80::1%eth0]:1234", "ip:fe80::/64"},
+		{"not an address", "ip:"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if got := ByClientIP(req); got != tt.want {
+			t.Errorf("ByClientIP(%s) = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package mockoidc is a minimal OpenID Connect provider for trying out and
+// testing the dashboard's single sign-on locally. It signs in whoever asks
+// as whatever email they type, so never expose it.
+package mockoidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	keyID   = "mockoidc"
+	codeTTL = time.Minute
+)
+
+// grant is an issued authorization code waiting to be redeemed.
+type grant struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	challenge     string
+	email         string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+// Provider serves discovery, keys, authorization and token endpoints under
+// its issuer URL.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	signer       jose.Signer
+	mux          *http.ServeMux
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// New creates a provider for issuer that accepts a single client.
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: keyID}},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	p := &Provider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		signer:       signer,
+		mux:          http.NewServeMux(),
+		grants:       make(map[string]grant),
+	}
+	p.mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("GET /jwks", p.jwks)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("POST /token", p.token)
+	return p, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: keyID, Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html><head><title>Mock OIDC sign-in</title></head>
+<body style="font-family: sans-serif; max-width: 24rem; margin: 4rem auto">
+<h1>Mock OIDC sign-in</h1>
+<form method="POST">
+  <p><label>Email <input type="email" name="email" required autofocus></label></p>
+  <p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+  <p><button type="submit">Sign in</button> <button type="submit" name="deny" value="true">Deny</button></p>
+</form>
+</body></html>`))
+
+// authorize shows a form asking which email to sign in as. A login_hint
+// skips the form, which lets scripts run the whole flow.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if query.Get("client_id") != p.clientID || err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "unknown client_id or invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	reply := redirectURI.Query()
+	reply.Set("state", query.Get("state"))
+
+	email, verified := query.Get("login_hint"), true
+	if r.Method == http.MethodPost {
+		email, verified = r.PostFormValue("email"), r.PostFormValue("email_verified") == "true"
+		if r.PostFormValue("deny") != "" {
+			reply.Set("error", "access_denied")
+			redirectURI.RawQuery = reply.Encode()
+			http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+			return
+		}
+	}
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html")
+		authorizePage.Execute(w, nil)
+		return
+	}
+
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		reply.Set("error", "invalid_request")
+		redirectURI.RawQuery = reply.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.grants[code] = grant{
+		clientID:      p.clientID,
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		challenge:     query.Get("code_challenge"),
+		email:         email,
+		emailVerified: verified,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	reply.Set("code", code)
+	redirectURI.RawQuery = reply.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	// Codes are single use
+	code := r.PostFormValue("code")
+	p.mu.Lock()
+	g, found := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !found || time.Now().After(g.expiresAt) || g.redirectURI != r.PostFormValue("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	// The same email always gets the same subject
+	subject := sha256.Sum256([]byte(g.email))
+	now := time.Now()
+	claims, _ := json.Marshal(map[string]any{
+		"iss":            p.issuer,
+		"sub":            hex.EncodeToString(subject[:8]),
+		"aud":            g.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+	})
+	signed, err := p.signer.Sign(claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	idToken, err := signed.CompactSerialize()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+)
+
+// API key scopes. ScopeAdmin grants every other scope.
+const (
+	ScopeLinksRead     = "links:read"
+	ScopeLinksWrite    = "links:write"
+	ScopeAnalyticsRead = "analytics:read"
+	ScopeAdmin         = "admin"
+)
+
+// Scopes lists every scope an API key can be given.
+var Scopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeAnalyticsRead, ScopeAdmin}
+
+// APIKey describes an issued key. The secret itself is never stored, only
+// its hash; Prefix is kept so that operators can tell keys apart.
+type APIKey struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// UserID is the user the key acts for; links it creates belong to
+	// them. Nil for service keys that belong to no user.
+	UserID   *int64 `json:"user_id"`
+	Username string `json:"user,omitempty"`
+	// UserRole is the role of the key's user, which bounds the key's
+	// scopes.
+	UserRole   Role       `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// CreateAPIKeyRequest is the body of POST /api/v1/keys.
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// User is the username the key acts for. It defaults to the caller.
+	User string `json:"user,omitempty"`
+}
+
+// CreateAPIKeyResponse returns a new key together with its secret, which
+// is shown only once.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+type APIKeyListResponse struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// Principal is the authenticated caller of a request: an API key or a
+// signed-in dashboard user.
+type Principal struct {
+	// KeyID is the api_keys row, or zero for the legacy AUTH_TOKEN and
+	// for users.
+	KeyID int64
+	// UserID is the signed-in user or the user an API key belongs to,
+	// and zero otherwise.
+	UserID int64
+	// Name is recorded as a link's created_by: the username for users and
+	// keys that belong to one, otherwise the key's name.
+	Name string
+	// Role is the user's role, or for keys without a user the role their
+	// scopes correspond to.
+	Role   Role
+	Scopes []string
+}
+
+// IsAdmin reports whether the principal may act on everything, including
+// links owned by others.
+func (p *Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+// CanModify reports whether the principal may change or delete link: its
+// owner or an admin.
+func (p *Principal) CanModify(link *LinkMapping) bool {
+	if p.IsAdmin() {
+		return true
+	}
+	return p.UserID != 0 && link.OwnerID != nil && *link.OwnerID == p.UserID
+}
+
+// HasScope reports whether the principal was granted scope, directly or
+// through ScopeAdmin.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
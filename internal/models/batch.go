@@ -0,0 +1,25 @@
+package models
+
+// BatchCreateRequest is the JSON body of POST /api/v1/links/batch.
+type BatchCreateRequest struct {
+	Links []CreateShortURLRequest `json:"links"`
+}
+
+// BatchCreateResult is the outcome of one item of a batch. Either the link
+// fields or Error and Code are set.
+type BatchCreateResult struct {
+	// Index is the item's position in the request, counting from 0.
+	Index       int    `json:"index"`
+	ShortCode   string `json:"short_code,omitempty"`
+	ShortURL    string `json:"short_url,omitempty"`
+	OriginalURL string `json:"original_url"`
+	Reused      bool   `json:"reused,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Code        string `json:"code,omitempty"`
+}
+
+type BatchCreateResponse struct {
+	Created int                 `json:"created"`
+	Failed  int                 `json:"failed"`
+	Results []BatchCreateResult `json:"results"`
+}
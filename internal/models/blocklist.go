@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Blocklist audit actions.
+const (
+	BlocklistRejected = "rejected"
+	BlocklistDisabled = "disabled"
+	BlocklistEnabled  = "enabled"
+)
+
+// BlocklistEvent is an audit record of the blocklist refusing a
+// destination, disabling a link, or an admin enabling it again.
+type BlocklistEvent struct {
+	ID     int64  `json:"id"`
+	Action string `json:"action"`
+	// ShortCode is empty for rejected links, which were never created.
+	ShortCode string `json:"short_code,omitempty"`
+	URL       string `json:"url"`
+	// Source and Entry name the list file and the entry that matched.
+	Source string `json:"source,omitempty"`
+	Entry  string `json:"entry,omitempty"`
+	// Actor is the principal whose request was refused or who disabled or
+	// enabled the link, or "recheck" for the periodic recheck.
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlocklistEventListResponse is the body of GET /api/v1/blocklist/events.
+type BlocklistEventListResponse struct {
+	Events []BlocklistEvent `json:"events"`
+}
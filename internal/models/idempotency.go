@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key header. Keys are scoped to the API key or user that sent
+// them.
+type IdempotencyRecord struct {
+	Scope string
+	Key   string
+	// RequestHash fingerprints the request the key was first used with,
+	// so that reusing the key for a different request can be refused.
+	RequestHash string
+	// StatusCode is zero while the first request is still being handled.
+	StatusCode  int
+	ContentType string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Completed reports whether the response has been stored.
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
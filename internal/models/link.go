@@ -1,9 +1,19 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Link states reported by LinkMapping.State.
+const (
+	LinkStateActive       = "active"
+	LinkStateExpired      = "expired"
+	LinkStateLimitReached = "limit_reached"
+	LinkStateDisabled     = "disabled"
+	LinkStateDeleted      = "deleted"
+)
+
 type LinkMapping struct {
 	ShortCode    string     `json:"short_code" db:"short_code"`
 	OriginalURL  string     `json:"original_url" db:"original_url"`
@@ -11,6 +21,74 @@ type LinkMapping struct {
 	CreatedBy    string     `json:"created_by" db:"created_by"`
 	ClickCount   int        `json:"click_count" db:"click_count"`
 	LastAccessed *time.Time `json:"last_accessed" db:"last_accessed"`
+	ExpiresAt    *time.Time `json:"expires_at" db:"expires_at"`
+	MaxClicks    *int       `json:"max_clicks" db:"max_clicks"`
+	// FallbackURL is where visits go once the link is no longer active.
+	FallbackURL string `json:"fallback_url,omitempty" db:"fallback_url"`
+	// OwnerID is the user who created the link, or nil for links created
+	// before ownership was recorded or by keys that belong to no user.
+	OwnerID *int64 `json:"owner_id" db:"owner_id"`
+	// DisabledAt is set when the link was disabled, either by hand or
+	// because its destination appeared on a blocklist.
+	DisabledAt     *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	DisabledReason string     `json:"disabled_reason,omitempty" db:"disabled_reason"`
+	// DisabledBy is the owner or admin who disabled the link by hand. It
+	// is empty for links taken down by the blocklist.
+	DisabledBy string `json:"disabled_by,omitempty" db:"disabled_by"`
+	// NormalizedURL is OriginalURL as normalized by urlpolicy.Normalize,
+	// which reuse_existing looks links up by. It is only set on writes.
+	NormalizedURL string `json:"-" db:"normalized_url"`
+	// CreatedByKeyID is the API key that created the link when it acts for
+	// no user, which reuse_existing looks such keys' links up by. It is
+	// only set on writes.
+	CreatedByKeyID *int64 `json:"-" db:"created_by_key"`
+	// DeletedAt is set when the link was deleted. Deleted links keep their
+	// clicks and history, and can be restored until they are purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy string     `json:"deleted_by,omitempty" db:"deleted_by"`
+}
+
+// Expired reports whether the link's expiry time has passed at now.
+func (l LinkMapping) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// LimitReached reports whether the link has used up its click limit.
+func (l LinkMapping) LimitReached() bool {
+	return l.MaxClicks != nil && l.ClickCount >= *l.MaxClicks
+}
+
+// TakenDown reports whether the link was disabled because its destination
+// or fallback is blocklisted. Such links never redirect, not even to their
+// fallback, and only an admin can re-enable them.
+func (l LinkMapping) TakenDown() bool {
+	return l.DisabledAt != nil && l.DisabledBy == ""
+}
+
+// State reports whether the link currently redirects.
+func (l LinkMapping) State() string {
+	switch {
+	case l.DeletedAt != nil:
+		return LinkStateDeleted
+	case l.DisabledAt != nil:
+		return LinkStateDisabled
+	case l.Expired(time.Now()):
+		return LinkStateExpired
+	case l.LimitReached():
+		return LinkStateLimitReached
+	default:
+		return LinkStateActive
+	}
+}
+
+// MarshalJSON adds the link's State as "status", so that API clients need
+// not work it out from the timestamps and counters themselves.
+func (l LinkMapping) MarshalJSON() ([]byte, error) {
+	type link LinkMapping // without this method
+	return json.Marshal(struct {
+		link
+		Status string `json:"status"`
+	}{link(l), l.State()})
 }
 
 type ClickAnalytics struct {
@@ -20,18 +98,75 @@ type ClickAnalytics struct {
 	UserAgent string    `json:"user_agent" db:"user_agent"`
 	IPAddress string    `json:"ip_address" db:"ip_address"`
 	Referrer  string    `json:"referrer" db:"referrer"`
+	EventID   string    `json:"-" db:"event_id"`
+	// Reason is empty for a normal redirect, otherwise the state of the
+	// inactive link that was visited, such as "expired".
+	Reason string `json:"reason,omitempty" db:"reason"`
+	// Counted is set when the click was already added to the link's
+	// click_count at redirect time, as happens for links with max_clicks.
+	Counted bool `json:"-"`
 }
 
+// CreateShortURLRequest is the body of POST /shorten and POST
+// /api/v1/links. CreatedBy is ignored, since links are attributed to the
+// authenticated caller; it is still accepted so that existing clients keep
+// working.
 type CreateShortURLRequest struct {
-	OriginalURL string `json:"original_url" form:"original_url"`
-	CustomCode  string `json:"custom_code" form:"custom_code"`
-	CreatedBy   string `json:"created_by" form:"created_by"`
+	OriginalURL string     `json:"original_url" form:"original_url"`
+	CustomCode  string     `json:"custom_code" form:"custom_code"`
+	CreatedBy   string     `json:"created_by" form:"created_by"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" form:"expires_at"`
+	MaxClicks   *int       `json:"max_clicks,omitempty" form:"max_clicks"`
+	FallbackURL string     `json:"fallback_url,omitempty" form:"fallback_url"`
+	// ReuseExisting returns the caller's newest active link to the same
+	// normalized URL, if there is one, instead of creating another. It is
+	// ignored when CustomCode is set.
+	ReuseExisting bool `json:"reuse_existing,omitempty" form:"reuse_existing"`
 }
 
 type CreateShortURLResponse struct {
 	ShortCode   string `json:"short_code"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	// Reused is set when an existing link was returned for
+	// reuse_existing.
+	Reused bool `json:"reused,omitempty"`
+}
+
+// UpdateLinkRequest is the body of PATCH /api/v1/links/{code}. Nil fields
+// are left unchanged.
+type UpdateLinkRequest struct {
+	OriginalURL *string             `json:"original_url,omitempty"`
+	ExpiresAt   Optional[time.Time] `json:"expires_at"`
+	MaxClicks   Optional[int]       `json:"max_clicks"`
+	FallbackURL Optional[string]    `json:"fallback_url"`
+}
+
+// Optional is an update field that tells an absent value apart from an
+// explicit null, which clears it.
+type Optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+type LinkListResponse struct {
+	Links      []LinkMapping `json:"links"`
+	Pagination *Pagination   `json:"pagination"`
 }
 
 type AnalyticsResponse struct {
@@ -49,4 +184,10 @@ type Pagination struct {
 	TotalItems  int `json:"total_items"`
 	HasNext     bool `json:"has_next"`
 	HasPrev     bool `json:"has_prev"`
-}
\ No newline at end of file
+}
+
+// ErrorResponse is the JSON error envelope returned by API endpoints.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
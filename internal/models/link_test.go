@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLinkMappingStatus(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	one := 1
+
+	tests := []struct {
+		name string
+		link LinkMapping
+		want string
+	}{
+		{"active", LinkMapping{}, LinkStateActive},
+		{"expired", LinkMapping{ExpiresAt: &past}, LinkStateExpired},
+		{"limit reached", LinkMapping{MaxClicks: &one, ClickCount: 1}, LinkStateLimitReached},
+		{"disabled", LinkMapping{DisabledAt: &past, ExpiresAt: &past}, LinkStateDisabled},
+		{"deleted", LinkMapping{DeletedAt: &past, DisabledAt: &past}, LinkStateDeleted},
+	}
+
+	for _, tt := range tests {
+		tt.link.ShortCode = "abc"
+		data, err := json.Marshal(tt.link)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if fields["status"] != tt.want || fields["short_code"] != "abc" {
+			t.Errorf("%s: got %s, want status %q alongside the link's fields", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestLinkMappingTakenDown(t *testing.T) {
+	now := time.Now()
+
+	if (LinkMapping{}).TakenDown() {
+		t.Error("enabled link reported taken down")
+	}
+	if !(LinkMapping{DisabledAt: &now}).TakenDown() {
+		t.Error("link disabled by the blocklist not reported taken down")
+	}
+	if (LinkMapping{DisabledAt: &now, DisabledBy: "alice"}).TakenDown() {
+		t.Error("link disabled by hand reported taken down")
+	}
+}
@@ -0,0 +1,69 @@
+package models
+
+// Role is what a dashboard user may do. Each role includes everything the
+// roles before it can do.
+type Role string
+
+const (
+	// RoleViewer can see links and analytics, including click details.
+	RoleViewer Role = "viewer"
+	// RoleCreator can also create links and change or delete their own.
+	RoleCreator Role = "creator"
+	// RoleAdmin can also change every link and manage users and API keys.
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists every role from least to most privileged.
+var Roles = []Role{RoleViewer, RoleCreator, RoleAdmin}
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, bool) {
+	for _, role := range Roles {
+		if string(role) == s {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// AtLeast reports whether r includes everything min can do.
+func (r Role) AtLeast(min Role) bool {
+	return r.rank() >= min.rank()
+}
+
+// Scopes returns the API scopes the role grants.
+func (r Role) Scopes() []string {
+	switch r {
+	case RoleAdmin:
+		return Scopes
+	case RoleCreator:
+		return []string{ScopeLinksRead, ScopeLinksWrite, ScopeAnalyticsRead}
+	case RoleViewer:
+		return []string{ScopeLinksRead, ScopeAnalyticsRead}
+	}
+	return nil
+}
+
+// RoleForScopes returns the role that matches what a set of API key
+// scopes allows, for keys that do not act for a user.
+func RoleForScopes(scopes []string) Role {
+	role := RoleViewer
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeAdmin:
+			return RoleAdmin
+		case ScopeLinksWrite:
+			role = RoleCreator
+		}
+	}
+	return role
+}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
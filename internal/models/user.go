@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+)
+
+// User is a dashboard account. Password hashes never leave the storage and
+// service layers.
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is a signed-in dashboard user. It is identified by a random
+// token kept in a cookie, of which only the hash is stored.
+type Session struct {
+	User User
+	// CSRFToken must accompany every state-changing request made with the
+	// session cookie.
+	CSRFToken string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Principal returns the caller a session authenticates, with the scopes of
+// the user's role.
+func (s *Session) Principal() *Principal {
+	return &Principal{
+		UserID: s.User.ID,
+		Name:   s.User.Username,
+		Role:   s.User.Role,
+		Scopes: s.User.Role.Scopes(),
+	}
+}
+
+// Identity is an account at an external identity provider, as asserted
+// by a verified ID token.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+// CreateUserRequest is the body of POST /api/v1/users.
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+}
+
+// UpdateUserRequest is the body of PATCH /api/v1/users/{username}. Fields
+// that are left out are not changed.
+type UpdateUserRequest struct {
+	Role     *Role   `json:"role"`
+	Password *string `json:"password"`
+}
+
+type UserListResponse struct {
+	Users []User `json:"users"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// LinkVersion records a link's destination after a change. Version 1 is
+// the destination the link was created with.
+type LinkVersion struct {
+	ShortCode   string `json:"short_code"`
+	Version     int    `json:"version"`
+	OriginalURL string `json:"original_url"`
+	// PreviousURL is the destination this version replaced, empty for the
+	// first version.
+	PreviousURL string `json:"previous_url,omitempty"`
+	// ChangedBy is the principal who made the change.
+	ChangedBy string `json:"changed_by"`
+	// RestoredVersion is set when the change was a rollback to that
+	// version.
+	RestoredVersion *int      `json:"restored_version,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// LinkVersionListResponse is the body of GET
+// /api/v1/links/{code}/versions, newest version first.
+type LinkVersionListResponse struct {
+	Versions []LinkVersion `json:"versions"`
+}
+
+// RollbackLinkRequest is the body of POST /api/v1/links/{code}/rollback.
+type RollbackLinkRequest struct {
+	Version int `json:"version" form:"version"`
+}
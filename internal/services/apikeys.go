@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+// Errors returned by APIKeyService.
+var (
+	ErrAPIKeyNotFound   = errors.New("API key not found")
+	ErrMissingKeyName   = errors.New("API key name is required")
+	ErrMissingKeyScopes = errors.New("at least one scope is required")
+	ErrInvalidScope     = errors.New("unknown scope")
+)
+
+const (
+	// apiKeyPrefix marks secrets issued by this service, which makes leaked
+	// keys easy to search for.
+	apiKeyPrefix = "lsk_"
+	// apiKeyDisplayLength is how much of a secret is stored in the clear to
+	// identify the key.
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	// lastUsedInterval throttles last_used_at writes for busy keys.
+	lastUsedInterval = time.Minute
+)
+
+// APIKeyService issues, revokes and checks API keys.
+type APIKeyService struct {
+	store storage.APIKeyStore
+}
+
+func NewAPIKeyService(store storage.APIKeyStore) *APIKeyService {
+	return &APIKeyService{store: store}
+}
+
+// CreateKey issues a new key acting for user, which may be nil for a
+// service key, and returns it together with its secret. The secret cannot
+// be recovered later.
+func (s *APIKeyService) CreateKey(name string, scopes []string, user *models.User) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrMissingKeyName
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + token
+
+	key := &models.APIKey{
+		Name:      name,
+		Prefix:    secret[:apiKeyDisplayLength],
+		Scopes:    scopes,
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	if user != nil {
+		key.UserID = &user.ID
+		key.Username = user.Username
+	}
+	if err := s.store.CreateAPIKey(key, hashToken(secret)); err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+func (s *APIKeyService) ListKeys() ([]models.APIKey, error) {
+	return s.store.ListAPIKeys()
+}
+
+// RevokeKey stops a key from authenticating. The key stays listed.
+func (s *APIKeyService) RevokeKey(id int64) error {
+	err := s.store.RevokeAPIKey(id, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// AuthenticateKey resolves a bearer token to its principal. It returns nil
+// and no error for unknown or revoked tokens. The legacy AUTH_TOKEN, when
+// set, is accepted as an admin. Keys that act for a user never get more
+// than the user's role allows.
+func (s *APIKeyService) AuthenticateKey(token string) (*models.Principal, error) {
+	if legacy := os.Getenv("AUTH_TOKEN"); legacy != "" && subtle.ConstantTimeCompare([]byte(token), []byte(legacy)) == 1 {
+		return &models.Principal{Name: "AUTH_TOKEN", Role: models.RoleAdmin, Scopes: models.Scopes}, nil
+	}
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, nil
+	}
+
+	key, err := s.store.GetAPIKeyByHash(hashToken(token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedInterval {
+		if err := s.store.TouchAPIKey(key.ID, now); err != nil {
+			// Not worth failing the request over
+			logger.Warn("Failed to record use of API key %d: %v", key.ID, err)
+		}
+	}
+
+	principal := &models.Principal{KeyID: key.ID, Name: key.Name, Scopes: key.Scopes}
+	if key.UserID != nil {
+		principal.UserID = *key.UserID
+		principal.Name = key.Username
+		principal.Scopes = limitScopes(key.Scopes, key.UserRole)
+	}
+	principal.Role = models.RoleForScopes(principal.Scopes)
+	return principal, nil
+}
+
+// limitScopes returns the scopes that both the key and role grant.
+func limitScopes(scopes []string, role models.Role) []string {
+	key := &models.Principal{Scopes: scopes}
+	var result []string
+	for _, scope := range role.Scopes() {
+		if key.HasScope(scope) {
+			result = append(result, scope)
+		}
+	}
+	return result
+}
+
+// normalizeScopes validates scopes and removes duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		if !isKnownScope(scope) {
+			return nil, fmt.Errorf("%w %q", ErrInvalidScope, scope)
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+	if len(result) == 0 {
+		return nil, ErrMissingKeyScopes
+	}
+	return result, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range models.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashToken returns the stored form of an API key or session token. Tokens
+// are 256 random bits, so a plain SHA-256 is enough; a slow password hash
+// would only add latency to every request.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
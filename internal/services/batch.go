@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+const defaultBatchMaxLinks = 500
+
+// Errors returned by CreateShortURLs for batches it will not process.
+var (
+	ErrBatchEmpty    = errors.New("batch contains no links")
+	ErrBatchTooLarge = errors.New("batch contains too many links")
+)
+
+// BatchResult is the outcome of one item passed to CreateShortURLs:
+// either Response or Err is set.
+type BatchResult struct {
+	Response *models.CreateShortURLResponse
+	Err      error
+}
+
+// CreateShortURLs creates a link owned by creator for each of reqs, all in
+// one transaction, and returns their results in order. Items that are
+// invalid or whose custom code is taken fail on their own while the rest
+// are created; any other error creates none of them and is returned.
+// Items with reuse_existing also reuse links created earlier in the same
+// batch.
+func (s *ShortenerService) CreateShortURLs(reqs []models.CreateShortURLRequest, creator *models.Principal) ([]BatchResult, error) {
+	if err := s.CheckBatchSize(len(reqs)); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(reqs))
+	links := make([]*models.LinkMapping, len(reqs))
+	for i, req := range reqs {
+		link, existing, err := s.prepareLink(req, creator)
+		switch {
+		case err != nil:
+			results[i].Err = err
+		case existing != nil:
+			results[i].Response = createResponse(existing, true)
+		default:
+			links[i] = link
+		}
+	}
+
+	err := s.store.CreateLinkBatch(func(batch storage.LinkBatch) error {
+		created := make(map[string]*models.LinkMapping)
+		for i, link := range links {
+			if link == nil {
+				continue
+			}
+			if reqs[i].ReuseExisting && reqs[i].CustomCode == "" {
+				if existing, ok := created[link.NormalizedURL]; ok {
+					links[i] = nil
+					results[i].Response = createResponse(existing, true)
+					continue
+				}
+			}
+
+			err := s.insertLink(link, reqs[i].CustomCode != "", batch.CreateLink)
+			if errors.Is(err, ErrCodeExists) {
+				links[i] = nil
+				results[i].Err = err
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			created[link.NormalizedURL] = link
+			results[i].Response = createResponse(link, false)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		if link != nil {
+			s.cache.Set(link)
+		}
+	}
+	return results, nil
+}
+
+// CheckBatchSize reports whether a batch of n links may be created, up to
+// BATCH_MAX_LINKS (500 by default).
+func (s *ShortenerService) CheckBatchSize(n int) error {
+	if n == 0 {
+		return ErrBatchEmpty
+	}
+	if max := getBatchMaxLinks(); n > max {
+		return fmt.Errorf("%w: at most %d are allowed", ErrBatchTooLarge, max)
+	}
+	return nil
+}
+
+// getBatchMaxLinks returns BATCH_MAX_LINKS, the most links one batch may
+// create.
+func getBatchMaxLinks() int {
+	n, err := strconv.Atoi(os.Getenv("BATCH_MAX_LINKS"))
+	if err != nil || n < 1 {
+		return defaultBatchMaxLinks
+	}
+	return n
+}
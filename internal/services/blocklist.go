@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/blocklist"
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+// Blocklist errors. ErrBlocklisted is wrapped together with ErrInvalidURL
+// or ErrInvalidFallback.
+var (
+	ErrBlocklisted      = errors.New("destination is on a phishing or malware blocklist")
+	ErrNotDisabled      = errors.New("link is not disabled")
+	ErrAlreadyDisabled  = errors.New("link is already disabled")
+	ErrStillBlocklisted = errors.New("link's destination is still on a blocklist")
+	ErrTakenDown        = errors.New("only an admin can re-enable a link taken down by the blocklist")
+)
+
+// recheckActor is the actor recorded for links disabled by RecheckLinks.
+const recheckActor = "recheck"
+
+// recheckPageSize is how many links RecheckLinks reads at a time.
+const recheckPageSize = 500
+
+// checkBlocklist records and refuses a blocklisted destination requested
+// by principal.
+func (s *ShortenerService) checkBlocklist(rawURL string, kind error, principal *models.Principal) error {
+	match := s.blocklist.Match(rawURL)
+	if match == nil {
+		return nil
+	}
+
+	event := &models.BlocklistEvent{
+		Action:    models.BlocklistRejected,
+		URL:       rawURL,
+		Source:    match.Source,
+		Entry:     match.Entry,
+		Actor:     principalName(principal),
+		CreatedAt: time.Now(),
+	}
+	logger.Warn("Refused blocklisted URL %q from %s (%s: %s)", rawURL, event.Actor, match.Source, match.Entry)
+	if err := s.store.RecordBlocklistEvent(event); err != nil {
+		logger.Error("Failed to record blocklist event: %v", err)
+	}
+
+	return fmt.Errorf("%w: %w", kind, ErrBlocklisted)
+}
+
+// blocklistMatch returns the entry matched by the link's destination or
+// fallback, if any.
+func (s *ShortenerService) blocklistMatch(link *models.LinkMapping) (string, *blocklist.Match) {
+	if match := s.blocklist.Match(link.OriginalURL); match != nil {
+		return link.OriginalURL, match
+	}
+	if link.FallbackURL != "" {
+		if match := s.blocklist.Match(link.FallbackURL); match != nil {
+			return link.FallbackURL, match
+		}
+	}
+	return "", nil
+}
+
+// RunBlocklistRecheck rechecks every link against the blocklist now and
+// then every blocklist RecheckInterval, reloading the files each time,
+// until ctx is cancelled. It does nothing when no blocklist files are
+// configured.
+func (s *ShortenerService) RunBlocklistRecheck(ctx context.Context) {
+	if !s.blocklist.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(s.blocklist.RecheckInterval())
+	defer ticker.Stop()
+
+	for {
+		disabled, err := s.RecheckLinks(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Blocklist recheck failed after disabling %d links: %v", disabled, err)
+		} else if err == nil {
+			logger.Info("Blocklist recheck against %d entries disabled %d links", s.blocklist.Size(), disabled)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.blocklist.Reload(); err != nil {
+			logger.Error("Failed to reload blocklist, keeping the previous entries: %v", err)
+		}
+	}
+}
+
+// RecheckLinks checks every link that is not already taken down against
+// the blocklist, taking down and recording those whose destination or
+// fallback is listed. Links disabled by hand are checked too, so that
+// their owner cannot bring a listed link back. It returns how many links
+// it took down.
+func (s *ShortenerService) RecheckLinks(ctx context.Context) (int, error) {
+	disabled := 0
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return disabled, err
+		}
+
+		links, err := s.store.ListLinksToRecheck(after, recheckPageSize)
+		if err != nil {
+			return disabled, err
+		}
+		if len(links) == 0 {
+			return disabled, nil
+		}
+		after = links[len(links)-1].ShortCode
+
+		for i := range links {
+			rawURL, match := s.blocklistMatch(&links[i])
+			if match == nil {
+				continue
+			}
+
+			event := &models.BlocklistEvent{
+				Action:    models.BlocklistDisabled,
+				ShortCode: links[i].ShortCode,
+				URL:       rawURL,
+				Source:    match.Source,
+				Entry:     match.Entry,
+				Actor:     recheckActor,
+				CreatedAt: time.Now(),
+			}
+			err := s.store.DisableLink(event, fmt.Sprintf("blocklisted by %s (%s)", match.Source, match.Entry))
+			if errors.Is(err, storage.ErrNotFound) {
+				// Deleted or taken down since it was read, perhaps by
+				// another instance
+				continue
+			}
+			if err != nil {
+				return disabled, err
+			}
+
+			logger.Warn("Disabled link '%s': %q is blocklisted by %s (%s)", event.ShortCode, rawURL, match.Source, match.Entry)
+			s.cache.Invalidate(event.ShortCode)
+			disabled++
+		}
+	}
+}
+
+// DisableLink disables a link by hand on behalf of principal, who must own
+// it or be an admin. The link stops redirecting its visitors to the
+// original URL and sends them to its fallback instead, like an expired
+// link, until the owner or an admin re-enables it.
+func (s *ShortenerService) DisableLink(shortCode string, principal *models.Principal) (*models.LinkMapping, error) {
+	link, err := s.authorize(shortCode, principal)
+	if err != nil {
+		return nil, err
+	}
+	if link.DeletedAt != nil {
+		return nil, ErrLinkDeleted
+	}
+	if link.DisabledAt != nil {
+		return nil, ErrAlreadyDisabled
+	}
+
+	actor := principalName(principal)
+	err = s.store.DisableLinkManually(shortCode, actor, "disabled by "+actor, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrAlreadyDisabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Invalidate(shortCode)
+	return s.GetLink(shortCode)
+}
+
+// EnableLink re-enables a disabled link on behalf of principal. The owner
+// or an admin may re-enable a link disabled by hand, but only an admin a
+// link taken down by the blocklist, which is recorded in the audit trail.
+// Links whose destination is still blocklisted stay disabled.
+func (s *ShortenerService) EnableLink(shortCode string, principal *models.Principal) (*models.LinkMapping, error) {
+	link, err := s.authorize(shortCode, principal)
+	if err != nil {
+		return nil, err
+	}
+	if link.DisabledAt == nil {
+		return nil, ErrNotDisabled
+	}
+	if link.TakenDown() && !principal.IsAdmin() {
+		return nil, ErrTakenDown
+	}
+	if _, match := s.blocklistMatch(link); match != nil {
+		return nil, ErrStillBlocklisted
+	}
+
+	if link.TakenDown() {
+		err = s.store.EnableLink(&models.BlocklistEvent{
+			Action:    models.BlocklistEnabled,
+			ShortCode: shortCode,
+			URL:       link.OriginalURL,
+			Actor:     principalName(principal),
+			CreatedAt: time.Now(),
+		})
+	} else {
+		err = s.store.EnableLinkManually(shortCode)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		// Re-enabled, or taken down by the recheck, since it was read
+		return nil, ErrNotDisabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Invalidate(shortCode)
+	return s.GetLink(shortCode)
+}
+
+// BlocklistEvents returns the latest blocklist audit records.
+func (s *ShortenerService) BlocklistEvents(limit int) ([]models.BlocklistEvent, error) {
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	return s.store.ListBlocklistEvents(limit)
+}
+
+func principalName(principal *models.Principal) string {
+	if principal == nil {
+		return ""
+	}
+	return principal.Name
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+const (
+	defaultDeletedLinkRetention = 30 * 24 * time.Hour
+	// deletedLinkPurgeInterval is how often deleted links past their
+	// retention are purged.
+	deletedLinkPurgeInterval = time.Hour
+)
+
+// Errors returned for deleted links.
+var (
+	ErrLinkDeleted = errors.New("link has been deleted")
+	ErrNotDeleted  = errors.New("link is not deleted")
+)
+
+// RestoreLink undeletes a link on behalf of principal, who must own it or
+// be an admin. A link that was disabled stays disabled.
+func (s *ShortenerService) RestoreLink(shortCode string, principal *models.Principal) (*models.LinkMapping, error) {
+	if _, err := s.authorize(shortCode, principal); err != nil {
+		return nil, err
+	}
+
+	err := s.store.RestoreLink(shortCode)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotDeleted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Invalidate(shortCode)
+	return s.GetLink(shortCode)
+}
+
+// RunDeletedLinkPurge purges links deleted more than DELETED_LINK_RETENTION
+// ago now and then every hour, until ctx is cancelled. It does nothing when
+// the retention is 0, which keeps deleted links forever.
+func (s *ShortenerService) RunDeletedLinkPurge(ctx context.Context) {
+	retention := getDeletedLinkRetention()
+	if retention == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(deletedLinkPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		purged, err := s.store.PurgeDeletedLinks(now.Add(-retention), now)
+		if err != nil {
+			logger.Error("Failed to purge deleted links: %v", err)
+		} else if len(purged) > 0 {
+			for _, code := range purged {
+				s.cache.Invalidate(code)
+			}
+			logger.Info("Purged %d links deleted more than %s ago", len(purged), retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// getDeletedLinkRetention reads how long deleted links are kept before
+// they are purged, with 0 meaning forever.
+func getDeletedLinkRetention() time.Duration {
+	value := os.Getenv("DELETED_LINK_RETENTION")
+	if value == "0" {
+		return 0
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		return defaultDeletedLinkRetention
+	}
+	return retention
+}
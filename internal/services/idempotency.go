@@ -0,0 +1,135 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+const (
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+	// idempotencyPendingTimeout is how long a claimed key may go without a
+	// stored response before it is taken to belong to a request that died
+	// with the server, and may be claimed again. Requests time out well
+	// before this.
+	idempotencyPendingTimeout = 2 * time.Minute
+	// idempotencyPurgeInterval is how often expired keys are deleted.
+	idempotencyPurgeInterval = time.Hour
+)
+
+// Errors returned by IdempotencyService.Begin.
+var (
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+// IdempotencyService stores responses to requests sent with an
+// Idempotency-Key header, so that retries of the same request get the
+// first response instead of repeating its effect.
+type IdempotencyService struct {
+	store storage.IdempotencyStore
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	nextPurge time.Time
+}
+
+func NewIdempotencyService(store storage.IdempotencyStore) *IdempotencyService {
+	return &IdempotencyService{store: store, ttl: getIdempotencyKeyTTL()}
+}
+
+// Begin claims key within scope for a request fingerprinted by
+// requestHash. It returns nil once the key is claimed, after which the
+// caller must either Complete or Release it. If the key was already used
+// for the same request the stored response is returned instead; a
+// different request gets ErrIdempotencyKeyReused, and a retry that
+// arrives while the first request is still running gets
+// ErrIdempotencyInProgress.
+func (s *IdempotencyService) Begin(scope, key, requestHash string) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+	s.purgeExpired(now)
+
+	claim := &models.IdempotencyRecord{
+		Scope:       scope,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+
+	// A second round covers a stale key deleted in the first
+	for i := 0; i < 2; i++ {
+		err := s.store.CreateIdempotencyKey(claim)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, storage.ErrConflict) {
+			return nil, err
+		}
+
+		existing, err := s.store.GetIdempotencyKey(scope, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stale := !now.Before(existing.ExpiresAt) ||
+			(!existing.Completed() && now.Sub(existing.CreatedAt) >= idempotencyPendingTimeout)
+		switch {
+		case stale:
+			if err := s.store.DeleteIdempotencyKey(scope, key); err != nil {
+				return nil, err
+			}
+		case existing.RequestHash != requestHash:
+			return nil, ErrIdempotencyKeyReused
+		case !existing.Completed():
+			return nil, ErrIdempotencyInProgress
+		default:
+			return existing, nil
+		}
+	}
+
+	return nil, ErrIdempotencyInProgress
+}
+
+// Complete stores the response to a claimed key.
+func (s *IdempotencyService) Complete(record *models.IdempotencyRecord) error {
+	return s.store.CompleteIdempotencyKey(record)
+}
+
+// Release gives up a claimed key without storing a response, so that the
+// request can be retried with it.
+func (s *IdempotencyService) Release(scope, key string) error {
+	return s.store.DeleteIdempotencyKey(scope, key)
+}
+
+// purgeExpired deletes expired keys, at most once per
+// idempotencyPurgeInterval.
+func (s *IdempotencyService) purgeExpired(now time.Time) {
+	s.mu.Lock()
+	if now.Before(s.nextPurge) {
+		s.mu.Unlock()
+		return
+	}
+	s.nextPurge = now.Add(idempotencyPurgeInterval)
+	s.mu.Unlock()
+
+	if err := s.store.DeleteExpiredIdempotencyKeys(now); err != nil {
+		logger.Warn("Failed to delete expired idempotency keys: %v", err)
+	}
+}
+
+func getIdempotencyKeyTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultIdempotencyKeyTTL
+	}
+	return ttl
+}
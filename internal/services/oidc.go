@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Errors returned by OIDCService.
+var (
+	ErrOIDCEmailNotVerified = errors.New("the identity provider has not verified this email address")
+	ErrOIDCDomainNotAllowed = errors.New("this email domain is not allowed to sign in")
+	ErrOIDCInvalidNonce     = errors.New("ID token nonce does not match the sign-in request")
+)
+
+// OIDCCallbackPath is where the identity provider sends the browser back
+// to. It must be registered with the provider as a redirect URI.
+const OIDCCallbackPath = "/auth/oidc/callback"
+
+// oidcTimeout bounds every request to the identity provider.
+const oidcTimeout = 10 * time.Second
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider.
+type OIDCConfig struct {
+	// IssuerURL enables OIDC when set; the provider's endpoints are
+	// discovered from it.
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of OIDCCallbackPath.
+	RedirectURL string
+	// AllowedDomains lists the email domains that may sign in; "*" allows
+	// any verified email address.
+	AllowedDomains []string
+	// DefaultRole is given to users created on their first sign-in.
+	DefaultRole models.Role
+	// ProviderName labels the sign-in button.
+	ProviderName string
+}
+
+// OIDCConfigFromEnv reads the OIDC_* environment variables. The redirect
+// URL defaults to OIDCCallbackPath under BASE_URL.
+func OIDCConfigFromEnv() OIDCConfig {
+	cfg := OIDCConfig{
+		IssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		DefaultRole:  models.Role(os.Getenv("OIDC_DEFAULT_ROLE")),
+		ProviderName: os.Getenv("OIDC_PROVIDER_NAME"),
+	}
+
+	if cfg.RedirectURL == "" {
+		baseURL := os.Getenv("BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080"
+		}
+		cfg.RedirectURL = strings.TrimSuffix(baseURL, "/") + OIDCCallbackPath
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = models.RoleViewer
+	}
+	if cfg.ProviderName == "" {
+		cfg.ProviderName = "SSO"
+	}
+	for _, domain := range strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			cfg.AllowedDomains = append(cfg.AllowedDomains, domain)
+		}
+	}
+
+	return cfg
+}
+
+// OIDCFlow is the state of one sign-in attempt, kept by the browser
+// between redirecting to the provider and the callback.
+type OIDCFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// OIDCService runs the OpenID Connect authorization code flow with PKCE.
+type OIDCService struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	// The provider is discovered on first use, so that the server starts
+	// even while the provider is unreachable
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDCService(cfg OIDCConfig) (*OIDCService, error) {
+	if cfg.ClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER_URL")
+	}
+	if len(cfg.AllowedDomains) == 0 {
+		return nil, errors.New(`OIDC_ALLOWED_DOMAINS is required with OIDC_ISSUER_URL (use "*" to allow any domain)`)
+	}
+	if _, ok := models.ParseRole(string(cfg.DefaultRole)); !ok {
+		return nil, fmt.Errorf("unknown OIDC_DEFAULT_ROLE %q", cfg.DefaultRole)
+	}
+
+	return &OIDCService{cfg: cfg, client: &http.Client{Timeout: oidcTimeout}}, nil
+}
+
+// ProviderName is the configured label for the provider.
+func (s *OIDCService) ProviderName() string {
+	return s.cfg.ProviderName
+}
+
+// DefaultRole is the role of users created on their first sign-in.
+func (s *OIDCService) DefaultRole() models.Role {
+	return s.cfg.DefaultRole
+}
+
+// AuthCodeURL starts a sign-in: it returns the provider URL to send the
+// browser to and the flow state to keep until the callback.
+func (s *OIDCService) AuthCodeURL(ctx context.Context) (string, *OIDCFlow, error) {
+	config, _, err := s.provider(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	flow := &OIDCFlow{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}
+
+	url := config.AuthCodeURL(flow.State, oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier))
+	return url, flow, nil
+}
+
+// Exchange redeems an authorization code for an ID token, verifies it
+// against flow and returns the identity it asserts. The caller must have
+// checked the callback's state against flow.State.
+func (s *OIDCService) Exchange(ctx context.Context, code string, flow *OIDCFlow) (*models.Identity, error) {
+	config, verifier, err := s.provider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = s.clientContext(ctx)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != flow.Nonce {
+		return nil, ErrOIDCInvalidNonce
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to read ID token claims: %w", err)
+	}
+	// Providers that leave email_verified out are trusted to only assert
+	// addresses they own
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		return nil, ErrOIDCEmailNotVerified
+	}
+	email := strings.ToLower(claims.Email)
+	if !s.domainAllowed(email) {
+		return nil, fmt.Errorf("%w: %s", ErrOIDCDomainNotAllowed, email)
+	}
+
+	return &models.Identity{Issuer: idToken.Issuer, Subject: idToken.Subject, Email: email}, nil
+}
+
+func (s *OIDCService) domainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range s.cfg.AllowedDomains {
+		if allowed == "*" || allowed == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// provider discovers the provider's endpoints and keys on first use.
+func (s *OIDCService) provider(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.oauth != nil {
+		return s.oauth, s.verifier, nil
+	}
+
+	// Key sets are refreshed in the background long after this request
+	// ends, so discovery must not use the request's context
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.WithoutCancel(ctx), s.client), s.cfg.IssuerURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	s.oauth = &oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  s.cfg.RedirectURL,
+		Scopes:       []string{oidc.ScopeOpenID, "email"},
+	}
+	s.verifier = provider.Verifier(&oidc.Config{ClientID: s.cfg.ClientID})
+	return s.oauth, s.verifier, nil
+}
+
+func (s *OIDCService) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(oidc.ClientContext(ctx, s.client), oauth2.HTTPClient, s.client)
+}
@@ -1,343 +1,572 @@
 package services
 
 import (
-	"crypto/rand"
-	"database/sql"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"net/url"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/avantifellows/link-shortener/internal/blocklist"
+	"github.com/avantifellows/link-shortener/internal/cache"
 	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/shortcode"
+	"github.com/avantifellows/link-shortener/internal/storage"
+	"github.com/avantifellows/link-shortener/internal/urlpolicy"
 )
 
+// Errors returned by CreateShortURL for invalid or conflicting input.
+// Callers can match them with errors.Is to choose a response status.
+var (
+	ErrInvalidURL        = errors.New("invalid URL")
+	ErrInvalidCustomCode = errors.New("invalid custom code format")
+	ErrCodeExists        = errors.New("custom code already exists")
+	ErrLinkNotFound      = errors.New("short code not found")
+	ErrInvalidExpiry     = errors.New("expires_at must be in the future")
+	ErrInvalidMaxClicks  = errors.New("max_clicks must be at least 1")
+	ErrInvalidFallback   = errors.New("invalid fallback URL")
+	ErrForbidden         = errors.New("only the link's owner or an admin can change it")
+	ErrReservedCode      = errors.New("custom code is reserved")
+	ErrOffensiveCode     = errors.New("custom code contains a word that is not allowed")
+)
+
+// Redirect is the outcome of resolving a short code for a visit.
+type Redirect struct {
+	Link *models.LinkMapping
+	// Destination is where to send the visitor: the original URL, or the
+	// fallback URL when the link is inactive. It is empty for an inactive
+	// link without a fallback, and for taken down and deleted links.
+	Destination string
+	// Reason is empty when the link is active, otherwise the link state
+	// that stopped the visit, such as models.LinkStateExpired.
+	Reason string
+	// Counted reports that the visit was already added to the link's click
+	// count and must not be counted again when the click is recorded.
+	Counted bool
+}
+
 type ShortenerService struct {
-	db *sql.DB
+	store     storage.Store
+	cache     cache.Cache
+	policy    *urlpolicy.Policy
+	blocklist *blocklist.Blocklist
+	reserved  *shortcode.Reserved
+	codes     shortcode.CodeGenerator
 }
 
-func NewShortenerService(db *sql.DB) *ShortenerService {
-	return &ShortenerService{db: db}
+func NewShortenerService(store storage.Store, linkCache cache.Cache, blocked *blocklist.Blocklist, reserved *shortcode.Reserved, codes shortcode.CodeGenerator) *ShortenerService {
+	return &ShortenerService{
+		store:     store,
+		cache:     linkCache,
+		policy:    urlpolicy.New(urlpolicy.ConfigFromEnv()),
+		blocklist: blocked,
+		reserved:  reserved,
+		codes:     codes,
+	}
+}
+
+// CreateShortURL creates a link owned by creator, who is also recorded as
+// its created_by.
+func (s *ShortenerService) CreateShortURL(req models.CreateShortURLRequest, creator *models.Principal) (*models.CreateShortURLResponse, error) {
+	link, existing, err := s.prepareLink(req, creator)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return createResponse(existing, true), nil
+	}
+
+	if err := s.insertLink(link, req.CustomCode != "", s.store.CreateLink); err != nil {
+		return nil, err
+	}
+	// Write through, replacing any cached "not found" for the code
+	s.cache.Set(link)
+
+	return createResponse(link, false), nil
 }
 
-func (s *ShortenerService) CreateShortURL(req models.CreateShortURLRequest) (*models.CreateShortURLResponse, error) {
+// prepareLink validates req and returns the link to create for it. When
+// req.ReuseExisting finds one of creator's links to the same destination,
+// that link is returned as existing instead.
+func (s *ShortenerService) prepareLink(req models.CreateShortURLRequest, creator *models.Principal) (link, existing *models.LinkMapping, err error) {
 	// Validate URL
-	if !isValidURL(req.OriginalURL) {
-		return nil, fmt.Errorf("invalid URL format")
+	if err := s.checkDestination(req.OriginalURL, ErrInvalidURL, creator); err != nil {
+		return nil, nil, err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, nil, ErrInvalidExpiry
+	}
+	if req.MaxClicks != nil && *req.MaxClicks < 1 {
+		return nil, nil, ErrInvalidMaxClicks
+	}
+	if req.FallbackURL != "" {
+		if err := s.checkDestination(req.FallbackURL, ErrInvalidFallback, creator); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	var shortCode string
-	var err error
+	link = &models.LinkMapping{
+		ShortCode:     req.CustomCode,
+		OriginalURL:   req.OriginalURL,
+		NormalizedURL: urlpolicy.Normalize(req.OriginalURL),
+		CreatedAt:     time.Now(),
+		ExpiresAt:     req.ExpiresAt,
+		MaxClicks:     req.MaxClicks,
+		FallbackURL:   req.FallbackURL,
+	}
+	if creator != nil {
+		link.CreatedBy = creator.Name
+		if creator.UserID != 0 {
+			link.OwnerID = &creator.UserID
+		} else if creator.KeyID != 0 {
+			link.CreatedByKeyID = &creator.KeyID
+		}
+	}
 
-	// Use custom code if provided and available
+	// Whether a custom code is free is only known once it is inserted
 	if req.CustomCode != "" {
 		if !isValidShortCode(req.CustomCode) {
-			return nil, fmt.Errorf("invalid custom code format")
+			return nil, nil, ErrInvalidCustomCode
 		}
-		
-		shortCode = req.CustomCode
-		// For custom codes, we'll handle conflicts in the database insert
-	} else {
-		// For generated codes, use retry logic with database insert
-		shortCode, err = s.generateUniqueShortCode(req.OriginalURL, req.CreatedBy)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create short code: %w", err)
+		if s.reserved.IsReserved(req.CustomCode) {
+			return nil, nil, ErrReservedCode
 		}
-		
-		// Code was successfully inserted, return response
-		baseURL := getBaseURL()
-		return &models.CreateShortURLResponse{
-			ShortCode:   shortCode,
-			ShortURL:    fmt.Sprintf("%s/%s", baseURL, shortCode),
-			OriginalURL: req.OriginalURL,
-		}, nil
-	}
-
-	// Handle custom code insertion (with potential conflict)
-	_, err = s.db.Exec(`
-		INSERT INTO link_mappings (short_code, original_url, created_at, created_by, click_count)
-		VALUES (?, ?, ?, ?, 0)
-	`, shortCode, req.OriginalURL, time.Now().Unix(), req.CreatedBy)
+		if s.reserved.IsOffensive(req.CustomCode) {
+			return nil, nil, ErrOffensiveCode
+		}
+		return link, nil, nil
+	}
 
-	if err != nil {
-		// Check if this is a constraint violation (code already exists)
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") || strings.Contains(err.Error(), "PRIMARY KEY constraint failed") {
-			return nil, fmt.Errorf("custom code already exists")
+	// Hand back the caller's existing link to the same destination. Two
+	// such requests racing each other may still both create a link; clients
+	// retrying after a timeout should send an Idempotency-Key instead.
+	if req.ReuseExisting && creator != nil {
+		existing, err := s.store.FindActiveLink(creator, link.NormalizedURL, link.CreatedAt)
+		if err == nil {
+			return nil, existing, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, err
 		}
-		return nil, fmt.Errorf("failed to store URL mapping: %w", err)
 	}
 
-	baseURL := getBaseURL()
-	return &models.CreateShortURLResponse{
-		ShortCode:   shortCode,
-		ShortURL:    fmt.Sprintf("%s/%s", baseURL, shortCode),
-		OriginalURL: req.OriginalURL,
-	}, nil
+	return link, nil, nil
 }
 
-func (s *ShortenerService) GetOriginalURL(shortCode string) (string, error) {
-	var originalURL string
-	err := s.db.QueryRow(`
-		SELECT original_url FROM link_mappings WHERE short_code = ?
-	`, shortCode).Scan(&originalURL)
+// insertLink stores a prepared link with create, under its custom code or
+// else a generated one.
+func (s *ShortenerService) insertLink(link *models.LinkMapping, custom bool, create func(*models.LinkMapping) error) error {
+	if !custom {
+		if err := s.generateUniqueShortCode(link, create); err != nil {
+			return fmt.Errorf("failed to create short code: %w", err)
+		}
+		return nil
+	}
 
-	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("short code not found")
+	err := create(link)
+	if errors.Is(err, storage.ErrConflict) {
+		return ErrCodeExists
 	}
+	return err
+}
+
+func createResponse(link *models.LinkMapping, reused bool) *models.CreateShortURLResponse {
+	return &models.CreateShortURLResponse{
+		ShortCode:   link.ShortCode,
+		ShortURL:    fmt.Sprintf("%s/%s", getBaseURL(), link.ShortCode),
+		OriginalURL: link.OriginalURL,
+		Reused:      reused,
+	}
+}
+
+func (s *ShortenerService) GetOriginalURL(shortCode string) (string, error) {
+	link, err := s.ResolveLink(shortCode)
 	if err != nil {
-		return "", fmt.Errorf("database error: %w", err)
+		return "", err
+	}
+	if link.DeletedAt != nil {
+		return "", ErrLinkDeleted
 	}
 
-	return originalURL, nil
+	return link.OriginalURL, nil
 }
 
-func (s *ShortenerService) TrackClick(shortCode, userAgent, ipAddress, referrer string) error {
-	// Record click analytics
-	_, err := s.db.Exec(`
-		INSERT INTO click_analytics (short_code, timestamp, user_agent, ip_address, referrer)
-		VALUES (?, ?, ?, ?, ?)
-	`, shortCode, time.Now().Unix(), userAgent, ipAddress, referrer)
+// ResolveLink looks up a short code for a redirect, going through the link
+// cache. Counters on the returned link may be stale; use GetLink when they
+// matter. The returned link must not be modified.
+func (s *ShortenerService) ResolveLink(shortCode string) (*models.LinkMapping, error) {
+	if link, found := s.cache.Get(shortCode); found {
+		if link == nil {
+			return nil, ErrLinkNotFound
+		}
+		return link, nil
+	}
 
+	link, err := s.GetLink(shortCode)
+	if errors.Is(err, ErrLinkNotFound) {
+		s.cache.SetMissing(shortCode)
+		return nil, err
+	}
 	if err != nil {
-		return fmt.Errorf("failed to record click analytics: %w", err)
+		return nil, err
 	}
 
-	// Update click count and last accessed
-	_, err = s.db.Exec(`
-		UPDATE link_mappings 
-		SET click_count = click_count + 1, last_accessed = ?
-		WHERE short_code = ?
-	`, time.Now().Unix(), shortCode)
+	s.cache.Fill(link)
+	return link, nil
+}
 
+// ResolveRedirect looks up a short code for a visit at now and enforces the
+// link's expiry and click limit. Visits to inactive links are sent to the
+// link's fallback_url, or DEFAULT_FALLBACK_URL when it has none; links
+// taken down by the blocklist and deleted links go nowhere.
+func (s *ShortenerService) ResolveRedirect(shortCode string, now time.Time) (*Redirect, error) {
+	link, reason, counted, err := s.checkActive(shortCode, now)
 	if err != nil {
-		return fmt.Errorf("failed to update click count: %w", err)
+		return nil, err
 	}
 
-	return nil
-}
-
-func (s *ShortenerService) BeginTransaction() (*sql.Tx, error) {
-	return s.db.Begin()
+	redirect := &Redirect{Link: link, Destination: link.OriginalURL, Reason: reason, Counted: counted}
+	// A taken down link's fallback was chosen by whoever chose its
+	// blocklisted destination, so a takedown answers 410 rather than
+	// redirect anywhere. Links disabled by hand use their fallbacks.
+	if reason == models.LinkStateDeleted || link.TakenDown() {
+		redirect.Destination = ""
+	} else if reason != "" {
+		redirect.Destination = link.FallbackURL
+		if redirect.Destination == "" {
+			redirect.Destination = getDefaultFallbackURL()
+		}
+	}
+	return redirect, nil
 }
 
-func (s *ShortenerService) TrackClickInTransaction(tx *sql.Tx, shortCode, userAgent, ipAddress, referrer string, timestamp time.Time) error {
-	// Record click analytics
-	_, err := tx.Exec(`
-		INSERT INTO click_analytics (short_code, timestamp, user_agent, ip_address, referrer)
-		VALUES (?, ?, ?, ?, ?)
-	`, shortCode, timestamp.Unix(), userAgent, ipAddress, referrer)
+// checkActive resolves a short code and reports why it may not redirect.
+// For links with max_clicks the visit is counted here, atomically, so that
+// concurrent redirects on any number of instances cannot exceed the limit.
+func (s *ShortenerService) checkActive(shortCode string, now time.Time) (link *models.LinkMapping, reason string, counted bool, err error) {
+	link, err = s.ResolveLink(shortCode)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if link.DeletedAt != nil {
+		return link, models.LinkStateDeleted, false, nil
+	}
+	if link.DisabledAt != nil {
+		return link, models.LinkStateDisabled, false, nil
+	}
+	if link.Expired(now) {
+		return link, models.LinkStateExpired, false, nil
+	}
+	if link.MaxClicks == nil {
+		return link, "", false, nil
+	}
+	if link.LimitReached() {
+		return link, models.LinkStateLimitReached, false, nil
+	}
 
+	claimed, err := s.store.ClaimClick(shortCode, now)
 	if err != nil {
-		return fmt.Errorf("failed to record click analytics: %w", err)
+		return nil, "", false, err
+	}
+	if claimed {
+		return link, "", true, nil
 	}
 
-	// Update click count and last accessed
-	_, err = tx.Exec(`
-		UPDATE link_mappings 
-		SET click_count = click_count + 1, last_accessed = ?
-		WHERE short_code = ?
-	`, timestamp.Unix(), shortCode)
+	// The claim was refused, so the cached copy is stale. Cache the fresh
+	// one so that later visits to an exhausted link skip the claim.
+	link, err = s.GetLink(shortCode)
+	if err != nil {
+		return nil, "", false, err
+	}
+	s.cache.Fill(link)
+
+	switch {
+	case link.DeletedAt != nil:
+		return link, models.LinkStateDeleted, false, nil
+	case link.DisabledAt != nil:
+		return link, models.LinkStateDisabled, false, nil
+	case link.Expired(now):
+		return link, models.LinkStateExpired, false, nil
+	case link.MaxClicks == nil:
+		return link, "", false, nil
+	default:
+		return link, models.LinkStateLimitReached, false, nil
+	}
+}
 
+// GetLink returns the stored mapping for a short code.
+func (s *ShortenerService) GetLink(shortCode string) (*models.LinkMapping, error) {
+	link, err := s.store.GetLink(shortCode)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrLinkNotFound
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update click count: %w", err)
+		return nil, err
 	}
 
-	return nil
+	return link, nil
 }
 
-func (s *ShortenerService) GetAnalytics() (*models.AnalyticsResponse, error) {
-	return s.GetAnalyticsPaginated(1, 50, "")
+// UpdateLink applies the fields set in req to an existing link on behalf
+// of principal, who must own it or be an admin.
+func (s *ShortenerService) UpdateLink(shortCode string, req models.UpdateLinkRequest, principal *models.Principal) (*models.LinkMapping, error) {
+	return s.updateLink(shortCode, req, principal, nil)
 }
 
-func (s *ShortenerService) GetAnalyticsPaginated(page, pageSize int, searchTerm string) (*models.AnalyticsResponse, error) {
-	if page < 1 {
-		page = 1
+// updateLink is UpdateLink, recording a change of destination as a
+// rollback to restoredVersion when that is set.
+func (s *ShortenerService) updateLink(shortCode string, req models.UpdateLinkRequest, principal *models.Principal, restoredVersion *int) (*models.LinkMapping, error) {
+	if req.OriginalURL != nil {
+		if err := s.checkDestination(*req.OriginalURL, ErrInvalidURL, principal); err != nil {
+			return nil, err
+		}
 	}
-	if pageSize < 1 || pageSize > 1000 {
-		pageSize = 50
+	// An expiry in the past is allowed here: it is how a link is retired
+	if req.MaxClicks.Value != nil && *req.MaxClicks.Value < 1 {
+		return nil, ErrInvalidMaxClicks
+	}
+	// An empty fallback clears it, like null
+	if req.FallbackURL.Value != nil && *req.FallbackURL.Value == "" {
+		req.FallbackURL.Value = nil
+	}
+	if req.FallbackURL.Value != nil {
+		if err := s.checkDestination(*req.FallbackURL.Value, ErrInvalidFallback, principal); err != nil {
+			return nil, err
+		}
+	}
+	current, err := s.authorize(shortCode, principal)
+	if err != nil {
+		return nil, err
+	}
+	if current.DeletedAt != nil {
+		return nil, ErrLinkDeleted
 	}
 
-	// Build WHERE clause for search
-	var whereClause string
-	var queryArgs []interface{}
-	var countArgs []interface{}
-	
-	if searchTerm != "" {
-		searchPattern := "%" + searchTerm + "%"
-		whereClause = "WHERE (short_code LIKE ? OR original_url LIKE ?)"
-		queryArgs = []interface{}{searchPattern, searchPattern}
-		countArgs = []interface{}{searchPattern, searchPattern}
-	}
-
-	// Get total count first
-	var totalLinks int
-	var totalClicks int
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*), COALESCE(SUM(click_count), 0)
-		FROM link_mappings %s
-	`, whereClause)
-	
-	err := s.db.QueryRow(countQuery, countArgs...).Scan(&totalLinks, &totalClicks)
+	update := storage.LinkUpdate{
+		OriginalURL:     req.OriginalURL,
+		ExpiresAt:       req.ExpiresAt,
+		MaxClicks:       req.MaxClicks,
+		FallbackURL:     req.FallbackURL,
+		ChangedBy:       principalName(principal),
+		ChangedAt:       time.Now(),
+		RestoredVersion: restoredVersion,
+	}
+	if req.OriginalURL != nil {
+		update.NormalizedURL = urlpolicy.Normalize(*req.OriginalURL)
+	}
+	err = s.store.UpdateLink(shortCode, update)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrLinkNotFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get totals: %w", err)
+		return nil, err
 	}
 
-	// Calculate pagination
-	offset := (page - 1) * pageSize
-	totalPages := (totalLinks + pageSize - 1) / pageSize
-
-	// Get paginated links
-	linkQuery := fmt.Sprintf(`
-		SELECT short_code, original_url, created_at, created_by, click_count, last_accessed
-		FROM link_mappings %s
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`, whereClause)
-	
-	// Add LIMIT and OFFSET to query args
-	queryArgs = append(queryArgs, pageSize, offset)
-	
-	rows, err := s.db.Query(linkQuery, queryArgs...)
+	link, err := s.GetLink(shortCode)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch links: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	var links []models.LinkMapping
+	// Invalidate first so that the old destination is dropped even if the
+	// write-through below cannot reach a shared cache
+	s.cache.Invalidate(shortCode)
+	s.cache.Set(link)
+	return link, nil
+}
 
-	for rows.Next() {
-		var link models.LinkMapping
-		var createdAt int64
-		var lastAccessed sql.NullInt64
+// DeleteLink deletes a link on behalf of principal, who must own it or be
+// an admin. The link stops redirecting but keeps its clicks and history,
+// and can be restored with RestoreLink until it is purged.
+func (s *ShortenerService) DeleteLink(shortCode string, principal *models.Principal) error {
+	if _, err := s.authorize(shortCode, principal); err != nil {
+		return err
+	}
 
-		err := rows.Scan(&link.ShortCode, &link.OriginalURL, &createdAt, &link.CreatedBy, &link.ClickCount, &lastAccessed)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan link: %w", err)
-		}
+	err := s.store.DeleteLink(shortCode, principalName(principal), time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrLinkDeleted
+	}
+	if err != nil {
+		return err
+	}
 
-		link.CreatedAt = time.Unix(createdAt, 0)
-		if lastAccessed.Valid {
-			t := time.Unix(lastAccessed.Int64, 0)
-			link.LastAccessed = &t
-		}
+	s.cache.Invalidate(shortCode)
+	return nil
+}
+
+// authorize checks that principal may modify the link, reading ownership
+// from the database rather than the cache, and returns the link.
+func (s *ShortenerService) authorize(shortCode string, principal *models.Principal) (*models.LinkMapping, error) {
+	link, err := s.GetLink(shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil || !principal.CanModify(link) {
+		return nil, ErrForbidden
+	}
+	return link, nil
+}
+
+// CacheStats reports the link cache counters.
+func (s *ShortenerService) CacheStats() cache.Stats {
+	return s.cache.Stats()
+}
+
+// CodeStats reports the short code generator's counters.
+func (s *ShortenerService) CodeStats() shortcode.GeneratorStats {
+	return s.codes.Stats()
+}
+
+// RecordClicks stores a batch of clicks in a single transaction. Clicks
+// whose EventID has already been recorded are ignored, which makes
+// replaying the click journal safe.
+func (s *ShortenerService) RecordClicks(clicks []models.ClickAnalytics) error {
+	return s.store.RecordClicks(clicks)
+}
 
-		links = append(links, link)
+func (s *ShortenerService) GetAnalytics() (*models.AnalyticsResponse, error) {
+	return s.GetAnalyticsPaginated(1, 50, "", nil)
+}
+
+// GetAnalyticsPaginated returns one page of links with totals and recent
+// clicks, limited to links owned by ownerID when it is set.
+func (s *ShortenerService) GetAnalyticsPaginated(page, pageSize int, searchTerm string, ownerID *int64) (*models.AnalyticsResponse, error) {
+	result, pagination, err := s.queryLinks(page, pageSize, searchTerm, ownerID, false)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get recent clicks
-	clickRows, err := s.db.Query(`
-		SELECT id, short_code, timestamp, user_agent, ip_address, referrer
-		FROM click_analytics 
-		ORDER BY timestamp DESC 
-		LIMIT 50
-	`)
+	recentClicks, err := s.store.RecentClicks(50, ownerID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch recent clicks: %w", err)
+		return nil, err
 	}
-	defer clickRows.Close()
 
-	var recentClicks []models.ClickAnalytics
-	for clickRows.Next() {
-		var click models.ClickAnalytics
-		var timestamp int64
+	return &models.AnalyticsResponse{
+		Links:        result.Links,
+		TotalLinks:   result.TotalLinks,
+		TotalClicks:  result.TotalClicks,
+		RecentClicks: recentClicks,
+		Pagination:   pagination,
+	}, nil
+}
 
-		err := clickRows.Scan(&click.ID, &click.ShortCode, &timestamp, &click.UserAgent, &click.IPAddress, &click.Referrer)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan click: %w", err)
-		}
+// ListLinks returns one page of links, newest first, optionally filtered by
+// a search term matched against the short code and original URL and by
+// owner. Deleted links are listed on their own, when deleted is set.
+func (s *ShortenerService) ListLinks(page, pageSize int, searchTerm string, ownerID *int64, deleted bool) (*models.LinkListResponse, error) {
+	result, pagination, err := s.queryLinks(page, pageSize, searchTerm, ownerID, deleted)
+	if err != nil {
+		return nil, err
+	}
 
-		click.Timestamp = time.Unix(timestamp, 0)
-		recentClicks = append(recentClicks, click)
+	links := result.Links
+	if links == nil {
+		links = []models.LinkMapping{}
 	}
 
-	return &models.AnalyticsResponse{
-		Links:        links,
-		TotalLinks:   totalLinks,
-		TotalClicks:  totalClicks,
-		RecentClicks: recentClicks,
-		Pagination: &models.Pagination{
-			CurrentPage: page,
-			TotalPages:  totalPages,
-			PageSize:    pageSize,
-			TotalItems:  totalLinks,
-			HasNext:     page < totalPages,
-			HasPrev:     page > 1,
-		},
+	return &models.LinkListResponse{
+		Links:      links,
+		Pagination: pagination,
 	}, nil
 }
 
-func (s *ShortenerService) generateUniqueShortCode(originalURL, createdBy string) (string, error) {
-	const maxAttempts = 10
-	
-	for i := 0; i < maxAttempts; i++ {
-		// Generate 4 random bytes for 4-character code (like Firebase Dynamic Links)
-		bytes := make([]byte, 3)
-		if _, err := rand.Read(bytes); err != nil {
-			return "", err
-		}
-		
-		// Use URL-safe base64 encoding and remove padding
-		code := strings.TrimRight(base64.URLEncoding.EncodeToString(bytes), "=")
-		
-		// Take exactly 4 characters (base64 of 3 bytes = 4 chars)
-		if len(code) >= 4 {
-			code = code[:4]
+func (s *ShortenerService) queryLinks(page, pageSize int, searchTerm string, ownerID *int64, deleted bool) (*storage.LinkPage, *models.Pagination, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 1000 {
+		pageSize = 50
+	}
+
+	result, err := s.store.ListLinks(storage.LinkFilter{
+		Page:     page,
+		PageSize: pageSize,
+		Search:   searchTerm,
+		OwnerID:  ownerID,
+		Deleted:  deleted,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Calculate pagination
+	totalPages := (result.TotalLinks + pageSize - 1) / pageSize
+
+	return result, &models.Pagination{
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		PageSize:    pageSize,
+		TotalItems:  result.TotalLinks,
+		HasNext:     page < totalPages,
+		HasPrev:     page > 1,
+	}, nil
+}
+
+// generateUniqueShortCode inserts link with create under a code from the
+// code generator, trying further codes on collisions.
+func (s *ShortenerService) generateUniqueShortCode(link *models.LinkMapping, create func(*models.LinkMapping) error) error {
+	for attempt := 0; ; attempt++ {
+		code, err := s.codes.Next(attempt)
+		if err != nil {
+			return fmt.Errorf("failed to generate unique short code after %d attempts: %w", attempt, err)
 		}
-		
+
 		// Attempt to insert directly into database - this is atomic
-		_, err := s.db.Exec(`
-			INSERT INTO link_mappings (short_code, original_url, created_at, created_by, click_count)
-			VALUES (?, ?, ?, ?, 0)
-		`, code, originalURL, time.Now().Unix(), createdBy)
-		
-		if err == nil {
-			// Success! Code was unique and inserted
-			return code, nil
-		}
-		
+		link.ShortCode = code
+		err = create(link)
+
 		// If it's a constraint violation, try again with a new code
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") || strings.Contains(err.Error(), "PRIMARY KEY constraint failed") {
+		if errors.Is(err, storage.ErrConflict) {
+			s.codes.Result(code, true)
 			continue
 		}
-		
-		// Other database error, return it
-		return "", fmt.Errorf("database error: %w", err)
-	}
-	
-	return "", fmt.Errorf("failed to generate unique short code after %d attempts", maxAttempts)
-}
+		if err != nil {
+			// Other database error, return it
+			return fmt.Errorf("database error: %w", err)
+		}
 
-func (s *ShortenerService) shortCodeExists(code string) bool {
-	var exists bool
-	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM link_mappings WHERE short_code = ?)`, code).Scan(&exists)
-	return err == nil && exists
+		// Success! Code was unique and inserted
+		s.codes.Result(code, false)
+		return nil
+	}
 }
 
-func isValidURL(str string) bool {
-	u, err := url.Parse(str)
-	return err == nil && u.Scheme != "" && u.Host != ""
+// checkDestination applies the destination policy and the blocklist to
+// rawURL, requested by principal. A rejection wraps both kind, which says
+// which URL was rejected, and either the *urlpolicy.Violation naming the
+// rule it broke or ErrBlocklisted.
+func (s *ShortenerService) checkDestination(rawURL string, kind error, principal *models.Principal) error {
+	if err := s.policy.Check(rawURL); err != nil {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return s.checkBlocklist(rawURL, kind, principal)
 }
 
 func isValidShortCode(code string) bool {
 	if len(code) < 3 || len(code) > 20 {
 		return false
 	}
-	
+
 	// Allow alphanumeric characters, hyphens, and underscores
 	for _, r := range code {
 		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_') {
 			return false
 		}
 	}
-	
+
 	return true
 }
 
+// getDefaultFallbackURL returns where visits to inactive links without their
+// own fallback go; empty means they get 410 Gone.
+func getDefaultFallbackURL() string {
+	return os.Getenv("DEFAULT_FALLBACK_URL")
+}
+
 func getBaseURL() string {
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
 		baseURL = "http://localhost:8080"
 	}
 	return baseURL
-}
\ No newline at end of file
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/logger"
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by UserService.
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUsernameTaken      = errors.New("username is already taken")
+	ErrInvalidUsername    = errors.New("username must be 1-254 characters without spaces")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong    = errors.New("password must be at most 72 bytes")
+	ErrInvalidRole        = errors.New("role must be viewer, creator or admin")
+	ErrLastAdmin          = errors.New("at least one admin must remain")
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after 72 bytes, so longer passwords are
+	// refused rather than silently truncated.
+	maxPasswordBytes  = 72
+	defaultSessionTTL = 12 * time.Hour
+)
+
+// UserStore is the persistence UserService needs.
+type UserStore interface {
+	storage.UserStore
+	storage.SessionStore
+	storage.IdentityStore
+}
+
+// UserService manages dashboard accounts and their sessions.
+type UserService struct {
+	store      UserStore
+	sessionTTL time.Duration
+}
+
+func NewUserService(store UserStore) *UserService {
+	return &UserService{store: store, sessionTTL: getSessionTTL()}
+}
+
+// CreateUser adds a dashboard account. Usernames are case-insensitive.
+func (s *UserService) CreateUser(username, password string, role models.Role) (*models.User, error) {
+	if _, ok := models.ParseRole(string(role)); !ok {
+		return nil, ErrInvalidRole
+	}
+	username, err := normalizeUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{Username: username, Role: role, CreatedAt: time.Now().Truncate(time.Second)}
+	if err := s.store.CreateUser(user, hash); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *UserService) ListUsers() ([]models.User, error) {
+	return s.store.ListUsers()
+}
+
+// SetPassword changes a user's password and signs out their sessions.
+func (s *UserService) SetPassword(username, password string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.SetUserPassword(user.ID, hash); err != nil {
+		return err
+	}
+	return s.store.DeleteUserSessions(user.ID)
+}
+
+// SetRole changes a user's role. The last admin cannot be demoted.
+func (s *UserService) SetRole(username string, role models.Role) (*models.User, error) {
+	if _, ok := models.ParseRole(string(role)); !ok {
+		return nil, ErrInvalidRole
+	}
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+	if err := s.checkNotLastAdmin(user); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.SetUserRole(user.ID, role); err != nil {
+		return nil, err
+	}
+	user.Role = role
+	return user, nil
+}
+
+// DeleteUser removes a user. The last admin cannot be deleted.
+func (s *UserService) DeleteUser(username string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNotLastAdmin(user); err != nil {
+		return err
+	}
+	return s.store.DeleteUser(user.ID)
+}
+
+// checkNotLastAdmin returns ErrLastAdmin if user is the only admin left,
+// who could otherwise lock everyone out of user management.
+func (s *UserService) checkNotLastAdmin(user *models.User) error {
+	if user.Role != models.RoleAdmin {
+		return nil
+	}
+	admins, err := s.store.CountUsersWithRole(models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// Login checks a username and password and starts a session. It returns
+// the session and the token to hand to the browser.
+func (s *UserService) Login(username, password string) (*models.Session, string, error) {
+	username, err := normalizeUsername(username)
+	if err != nil {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	user, hash, err := s.store.GetUserByUsername(username)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, "", err
+	}
+	if user == nil || hash == "" {
+		// Spend as long as a real check so that response times do not
+		// reveal which usernames exist or sign in only through SSO
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, "", ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	return s.startSession(user)
+}
+
+// LoginIdentity starts a session for an account verified by an external
+// identity provider. The first time an account signs in it is linked to
+// the user whose username is its email address, and that user is created
+// with role if there is none. Users created this way have no password.
+func (s *UserService) LoginIdentity(identity *models.Identity, role models.Role) (*models.Session, string, error) {
+	user, err := s.store.GetUserByIdentity(identity.Issuer, identity.Subject)
+	if errors.Is(err, storage.ErrNotFound) {
+		user, err = s.linkIdentity(identity, role)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s.startSession(user)
+}
+
+func (s *UserService) linkIdentity(identity *models.Identity, role models.Role) (*models.User, error) {
+	username, err := normalizeUsername(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	user, _, err := s.store.GetUserByUsername(username)
+	if errors.Is(err, storage.ErrNotFound) {
+		user = &models.User{Username: username, Role: role, CreatedAt: time.Now().Truncate(time.Second)}
+		err = s.store.CreateUser(user, "")
+		if err == nil {
+			logger.Info("Created %s %s on first sign-in from %s", role, username, identity.Issuer)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.CreateIdentity(identity, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// startSession creates a session for user and returns it with the token
+// to hand to the browser.
+func (s *UserService) startSession(user *models.User) (*models.Session, string, error) {
+	now := time.Now()
+	if err := s.store.DeleteExpiredSessions(now); err != nil {
+		logger.Warn("Failed to delete expired sessions: %v", err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := &models.Session{
+		User:      *user,
+		CSRFToken: csrfToken,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	if err := s.store.CreateSession(session, hashToken(token)); err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// AuthenticateSession resolves a session token. It returns nil and no
+// error for unknown or expired sessions.
+func (s *UserService) AuthenticateSession(token string) (*models.Session, error) {
+	session, err := s.store.GetSession(hashToken(token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, nil
+	}
+
+	return session, nil
+}
+
+// Logout ends the session identified by token.
+func (s *UserService) Logout(token string) error {
+	return s.store.DeleteSession(hashToken(token))
+}
+
+// GetUser looks a user up by username.
+func (s *UserService) GetUser(username string) (*models.User, error) {
+	username, err := normalizeUsername(username)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	user, _, err := s.store.GetUserByUsername(username)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+func normalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" || len(username) > 254 || strings.ContainsAny(username, " \t\r\n") {
+		return "", ErrInvalidUsername
+	}
+	return username, nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len([]rune(password)) < minPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordBytes {
+		return "", ErrPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash returns a hash to compare against for unknown users.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// randomToken returns 256 random bits, URL-safe encoded.
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func getSessionTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultSessionTTL
+	}
+	return ttl
+}
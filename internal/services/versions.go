@@ -0,0 +1,41 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+	"github.com/avantifellows/link-shortener/internal/storage"
+)
+
+// ErrVersionNotFound is returned by RollbackLink for a version the link
+// does not have.
+var ErrVersionNotFound = errors.New("link has no such version")
+
+// LinkVersions returns the destination history of a link, newest first.
+func (s *ShortenerService) LinkVersions(shortCode string) ([]models.LinkVersion, error) {
+	if _, err := s.GetLink(shortCode); err != nil {
+		return nil, err
+	}
+	return s.store.ListLinkVersions(shortCode)
+}
+
+// RollbackLink points a link back at the destination it had in version,
+// on behalf of principal, who must own it or be an admin. The rollback is
+// itself recorded as a new version, so it can be undone the same way. The
+// old destination is checked against the URL policy and blocklists again,
+// as they may have changed since.
+func (s *ShortenerService) RollbackLink(shortCode string, version int, principal *models.Principal) (*models.LinkMapping, error) {
+	if _, err := s.authorize(shortCode, principal); err != nil {
+		return nil, err
+	}
+
+	v, err := s.store.GetLinkVersion(shortCode, version)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.updateLink(shortCode, models.UpdateLinkRequest{OriginalURL: &v.OriginalURL}, principal, &version)
+}
@@ -0,0 +1,243 @@
+package shortcode
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// DefaultAlphabet leaves out characters that are easily confused when a
+// link is read aloud or typed: 0, O, o, 1, l and I.
+const DefaultAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// Code length limits, matching what custom codes may use.
+const (
+	MinLength = 3
+	MaxLength = 20
+)
+
+// attemptsPerLength is how many collisions in a row a single link
+// tolerates before trying longer codes.
+const attemptsPerLength = 3
+
+// ErrExhausted is returned by Next when no more candidates will be tried.
+var ErrExhausted = errors.New("no unused short code found")
+
+// CodeGenerator picks the codes for links created without a custom code.
+// ShortenerService asks for candidates until one is free, reporting every
+// outcome so that implementations can adapt, and implementations must be
+// safe for concurrent use.
+type CodeGenerator interface {
+	// Next returns the candidate for the given attempt, counting from 0
+	// for each link, or ErrExhausted to give up.
+	Next(attempt int) (string, error)
+	// Result reports whether a candidate was already taken.
+	Result(code string, collided bool)
+	Stats() GeneratorStats
+}
+
+// GeneratorStats are the counters reported by GET /api/v1/stats.
+type GeneratorStats struct {
+	Length       int    `json:"length"`
+	AlphabetSize int    `json:"alphabet_size"`
+	Attempts     uint64 `json:"attempts"`
+	Collisions   uint64 `json:"collisions"`
+	// ReservedSkips counts random codes thrown away as reserved or
+	// offensive before they were tried.
+	ReservedSkips uint64 `json:"reserved_skips"`
+	// Grows counts how often the code length was increased.
+	Grows uint64 `json:"grows"`
+	// CollisionRate is the recent share of attempts that collided.
+	CollisionRate float64 `json:"collision_rate"`
+}
+
+// GeneratorConfig configures a RandomGenerator.
+type GeneratorConfig struct {
+	Alphabet string
+	// Length is the starting code length.
+	Length    int
+	MaxLength int
+	// GrowAt is the recent collision rate at which the length grows by
+	// one. A random code collides about as often as the share of the
+	// keyspace already in use.
+	GrowAt float64
+	// Window is roughly how many recent attempts CollisionRate covers.
+	Window int
+}
+
+// GeneratorConfigFromEnv reads the SHORT_CODE_* environment variables.
+func GeneratorConfigFromEnv() GeneratorConfig {
+	cfg := GeneratorConfig{
+		Alphabet:  DefaultAlphabet,
+		Length:    envInt("SHORT_CODE_LENGTH", 4),
+		MaxLength: envInt("SHORT_CODE_MAX_LENGTH", 12),
+		GrowAt:    0.1,
+		Window:    100,
+	}
+	if alphabet := os.Getenv("SHORT_CODE_ALPHABET"); alphabet != "" {
+		cfg.Alphabet = alphabet
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SHORT_CODE_GROW_AT"), 64); err == nil && v > 0 && v < 1 {
+		cfg.GrowAt = v
+	}
+	return cfg
+}
+
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// RandomGenerator draws codes uniformly from an alphabet. It starts at the
+// configured length and lengthens codes for good once the recent collision
+// rate reaches GrowAt. Within a single link, every few collisions in a row
+// move on to longer codes, so a link only fails once MaxLength is
+// exhausted.
+type RandomGenerator struct {
+	cfg      GeneratorConfig
+	reserved *Reserved
+
+	mu    sync.Mutex
+	stats GeneratorStats
+}
+
+// NewRandomGenerator returns a generator that skips codes reserved in
+// reserved.
+func NewRandomGenerator(cfg GeneratorConfig, reserved *Reserved) (*RandomGenerator, error) {
+	if err := validateAlphabet(cfg.Alphabet); err != nil {
+		return nil, err
+	}
+	if cfg.Length < MinLength || cfg.Length > MaxLength {
+		return nil, fmt.Errorf("short code length must be between %d and %d", MinLength, MaxLength)
+	}
+	if cfg.MaxLength < cfg.Length || cfg.MaxLength > MaxLength {
+		return nil, fmt.Errorf("short code maximum length must be between %d and %d", cfg.Length, MaxLength)
+	}
+	if cfg.Window < 1 {
+		cfg.Window = 1
+	}
+
+	return &RandomGenerator{
+		cfg:      cfg,
+		reserved: reserved,
+		stats:    GeneratorStats{Length: cfg.Length, AlphabetSize: len(cfg.Alphabet)},
+	}, nil
+}
+
+// validateAlphabet checks that the alphabet only uses characters allowed in
+// short codes, each once.
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("short code alphabet needs at least 2 characters")
+	}
+	seen := make(map[rune]bool)
+	for _, r := range alphabet {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_') {
+			return fmt.Errorf("short code alphabet contains %q; only letters, digits, - and _ are allowed", r)
+		}
+		if seen[r] {
+			return fmt.Errorf("short code alphabet contains %q more than once", r)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+// Resume picks up the length reached before a restart: it moves the
+// current length past every length whose keyspace is already filled to
+// GrowAt by existing codes, given as counts by length. It returns the
+// resulting length.
+func (g *RandomGenerator) Resume(countsByLength map[int]int) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.stats.Length < g.cfg.MaxLength {
+		keyspace := math.Pow(float64(len(g.cfg.Alphabet)), float64(g.stats.Length))
+		if float64(countsByLength[g.stats.Length])/keyspace < g.cfg.GrowAt {
+			break
+		}
+		g.stats.Length++
+	}
+	return g.stats.Length
+}
+
+func (g *RandomGenerator) Next(attempt int) (string, error) {
+	g.mu.Lock()
+	length := g.stats.Length + attempt/attemptsPerLength
+	g.mu.Unlock()
+	if length > g.cfg.MaxLength {
+		return "", ErrExhausted
+	}
+
+	for {
+		code, err := g.random(length)
+		if err != nil {
+			return "", err
+		}
+		if g.reserved == nil || g.reserved.Allowed(code) {
+			return code, nil
+		}
+
+		g.mu.Lock()
+		g.stats.ReservedSkips++
+		g.mu.Unlock()
+	}
+}
+
+func (g *RandomGenerator) Result(code string, collided bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.stats.Attempts++
+	sample := 0.0
+	if collided {
+		g.stats.Collisions++
+		sample = 1
+	}
+	alpha := 1 / float64(g.cfg.Window)
+	g.stats.CollisionRate += alpha * (sample - g.stats.CollisionRate)
+
+	if g.stats.CollisionRate >= g.cfg.GrowAt && g.stats.Length < g.cfg.MaxLength {
+		g.stats.Length++
+		g.stats.Grows++
+		// The longer codes start out with a nearly empty keyspace
+		g.stats.CollisionRate = 0
+	}
+}
+
+func (g *RandomGenerator) Stats() GeneratorStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+// random draws length characters from the alphabet, rejecting bytes that
+// would favour its first characters.
+func (g *RandomGenerator) random(length int) (string, error) {
+	alphabet := g.cfg.Alphabet
+	limit := 256 - 256%len(alphabet)
+
+	code := make([]byte, 0, length)
+	buf := make([]byte, length*2)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit {
+				code = append(code, alphabet[b%byte(len(alphabet))])
+				if len(code) == length {
+					break
+				}
+			}
+		}
+	}
+	return string(code), nil
+}
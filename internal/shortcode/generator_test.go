@@ -0,0 +1,35 @@
+package shortcode
+
+import "testing"
+
+func TestResume(t *testing.T) {
+	// Two characters, so lengths 3, 4 and 5 have 8, 16 and 32 codes
+	cfg := GeneratorConfig{Alphabet: "ab", Length: 3, MaxLength: 5, GrowAt: 0.5, Window: 10}
+
+	tests := []struct {
+		name   string
+		counts map[int]int
+		want   int
+	}{
+		{"no links", nil, 3},
+		{"below the threshold", map[int]int{3: 3}, 3},
+		{"skips a filled length", map[int]int{3: 4, 4: 7}, 4},
+		{"skips several", map[int]int{3: 8, 4: 8, 5: 1}, 5},
+		{"stops at the maximum", map[int]int{3: 8, 4: 16, 5: 32}, 5},
+		{"ignores other lengths", map[int]int{2: 4, 6: 64}, 3},
+	}
+
+	for _, tt := range tests {
+		g, err := NewRandomGenerator(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := g.Resume(tt.counts); got != tt.want {
+			t.Errorf("%s: Resume = %d, want %d", tt.name, got, tt.want)
+		}
+		code, err := g.Next(0)
+		if err != nil || len(code) != tt.want {
+			t.Errorf("%s: Next(0) = %q, %v; want a %d-character code", tt.name, code, err, tt.want)
+		}
+	}
+}
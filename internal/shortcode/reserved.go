@@ -0,0 +1,116 @@
+// Package shortcode decides which short codes links may use.
+package shortcode
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// routeNames are the first path segments of the routes registered in
+// cmd/server/main.go, which a short code of the same name would shadow.
+// Keep it in sync when adding routes.
+var routeNames = []string{"analytics", "api", "auth", "health", "login", "logout", "shorten", "static"}
+
+// defaultProfanity is matched anywhere in a code, so it leaves out short
+// words that hide inside harmless ones (like "ass" in "class").
+var defaultProfanity = []string{
+	"bitch", "bollock", "cunt", "dildo", "fag", "fuck", "jizz", "nazi",
+	"nigga", "nigger", "porn", "pussy", "shit", "slut", "wank", "whore",
+}
+
+// leet undoes the digit-for-letter swaps used to slip words past filters.
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "9", "g", "-", "", "_", "")
+
+// Reserved lists the codes links may not use: whole words that would
+// shadow routes or are otherwise set aside, and offensive words that may
+// not appear anywhere in a code. Both are matched case-insensitively. It
+// is safe for concurrent use.
+type Reserved struct {
+	words     map[string]bool
+	profanity []string
+}
+
+// NewReserved reserves the route names and words, and rejects codes
+// containing any of profanity.
+func NewReserved(words, profanity []string) *Reserved {
+	r := &Reserved{words: make(map[string]bool)}
+	for _, word := range append(routeNames, words...) {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			r.words[word] = true
+		}
+	}
+	for _, word := range profanity {
+		if word = leet.Replace(strings.ToLower(strings.TrimSpace(word))); word != "" {
+			r.profanity = append(r.profanity, word)
+		}
+	}
+	return r
+}
+
+// ReservedFromEnv reserves the comma-separated RESERVED_CODES and the
+// words in RESERVED_CODES_FILE on top of the route names. Offensive words
+// are the built-in list plus PROFANITY_FILE. Files have one word per line;
+// blank lines and lines starting with # are skipped.
+func ReservedFromEnv() (*Reserved, error) {
+	words := strings.Split(os.Getenv("RESERVED_CODES"), ",")
+	if path := os.Getenv("RESERVED_CODES_FILE"); path != "" {
+		fromFile, err := readWords(path)
+		if err != nil {
+			return nil, fmt.Errorf("RESERVED_CODES_FILE: %w", err)
+		}
+		words = append(words, fromFile...)
+	}
+
+	profanity := defaultProfanity
+	if path := os.Getenv("PROFANITY_FILE"); path != "" {
+		fromFile, err := readWords(path)
+		if err != nil {
+			return nil, fmt.Errorf("PROFANITY_FILE: %w", err)
+		}
+		profanity = append(profanity[:len(profanity):len(profanity)], fromFile...)
+	}
+
+	return NewReserved(words, profanity), nil
+}
+
+func readWords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && line[0] != '#' {
+			words = append(words, line)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// IsReserved reports whether code is a reserved word.
+func (r *Reserved) IsReserved(code string) bool {
+	return r.words[strings.ToLower(code)]
+}
+
+// IsOffensive reports whether code contains an offensive word, also when
+// spelled with digits or split up by - and _.
+func (r *Reserved) IsOffensive(code string) bool {
+	normalized := leet.Replace(strings.ToLower(code))
+	for _, word := range r.profanity {
+		if strings.Contains(normalized, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether a link may use code.
+func (r *Reserved) Allowed(code string) bool {
+	return !r.IsReserved(code) && !r.IsOffensive(code)
+}
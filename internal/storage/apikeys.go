@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// apiKeySelect reads the columns scanAPIKey expects, with the owning
+// user's name and role.
+const apiKeySelect = `
+	SELECT k.id, k.name, k.prefix, k.user_id, u.username, u.role, k.scopes, k.created_at, k.last_used_at, k.revoked_at
+	FROM api_keys k
+	LEFT JOIN users u ON u.id = k.user_id`
+
+func (s *SQLStore) CreateAPIKey(key *models.APIKey, secretHash string) error {
+	err := s.conn().queryRow(`
+		INSERT INTO api_keys (name, prefix, secret_hash, scopes, created_at, user_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, key.Name, key.Prefix, secretHash, strings.Join(key.Scopes, " "), key.CreatedAt.Unix(), int64OrNil(key.UserID)).Scan(&key.ID)
+	if err != nil {
+		if s.db.Dialect.IsUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to store API key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) GetAPIKeyByHash(secretHash string) (*models.APIKey, error) {
+	row := s.conn().queryRow(apiKeySelect+` WHERE k.secret_hash = ?`, secretHash)
+
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *SQLStore) ListAPIKeys() ([]models.APIKey, error) {
+	rows, err := s.conn().query(apiKeySelect + ` ORDER BY k.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *SQLStore) RevokeAPIKey(id int64, at time.Time) error {
+	// Revoking twice keeps the original revocation time
+	result, err := s.conn().exec(`
+		UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?
+	`, at.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) TouchAPIKey(id int64, at time.Time) error {
+	if _, err := s.conn().exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, at.Unix(), id); err != nil {
+		return fmt.Errorf("failed to update API key last use: %w", err)
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var username, role sql.NullString
+	var createdAt int64
+	var userID, lastUsedAt, revokedAt sql.NullInt64
+
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &userID, &username, &role, &scopes, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	key.CreatedAt = time.Unix(createdAt, 0)
+	key.Username = username.String
+	key.UserRole = models.Role(role.String)
+	if userID.Valid {
+		key.UserID = &userID.Int64
+	}
+	if lastUsedAt.Valid {
+		t := time.Unix(lastUsedAt.Int64, 0)
+		key.LastUsedAt = &t
+	}
+	if revokedAt.Valid {
+		t := time.Unix(revokedAt.Int64, 0)
+		key.RevokedAt = &t
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+func (s *SQLStore) ListLinksToRecheck(after string, limit int) ([]models.LinkMapping, error) {
+	rows, err := s.conn().query(`
+		SELECT `+linkColumns+`
+		FROM link_mappings
+		WHERE (disabled_at IS NULL OR disabled_by IS NOT NULL) AND short_code > ?
+		ORDER BY short_code
+		LIMIT ?
+	`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []models.LinkMapping
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		links = append(links, *link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch links: %w", err)
+	}
+
+	return links, nil
+}
+
+func (s *SQLStore) DisableLink(event *models.BlocklistEvent, reason string) error {
+	return s.withTx(func(c conn) error {
+		result, err := c.exec(`
+			UPDATE link_mappings SET disabled_at = ?, disabled_reason = ?, disabled_by = NULL
+			WHERE short_code = ? AND (disabled_at IS NULL OR disabled_by IS NOT NULL)
+		`, event.CreatedAt.Unix(), reason, event.ShortCode)
+		if err != nil {
+			return fmt.Errorf("failed to disable link: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return insertBlocklistEvent(c, event)
+	})
+}
+
+func (s *SQLStore) EnableLink(event *models.BlocklistEvent) error {
+	return s.withTx(func(c conn) error {
+		result, err := c.exec(`
+			UPDATE link_mappings SET disabled_at = NULL, disabled_reason = NULL
+			WHERE short_code = ? AND disabled_at IS NOT NULL AND disabled_by IS NULL
+		`, event.ShortCode)
+		if err != nil {
+			return fmt.Errorf("failed to enable link: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return insertBlocklistEvent(c, event)
+	})
+}
+
+func (s *SQLStore) RecordBlocklistEvent(event *models.BlocklistEvent) error {
+	return insertBlocklistEvent(s.conn(), event)
+}
+
+func insertBlocklistEvent(c conn, event *models.BlocklistEvent) error {
+	err := c.queryRow(`
+		INSERT INTO blocklist_events (action, short_code, url, source, entry, actor, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, event.Action, stringOrNil(event.ShortCode), event.URL, stringOrNil(event.Source), stringOrNil(event.Entry), event.Actor, event.CreatedAt.Unix()).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record blocklist event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) ListBlocklistEvents(limit int) ([]models.BlocklistEvent, error) {
+	rows, err := s.conn().query(`
+		SELECT id, action, short_code, url, source, entry, actor, created_at
+		FROM blocklist_events
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocklist events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.BlocklistEvent{}
+	for rows.Next() {
+		var event models.BlocklistEvent
+		var shortCode, source, entry sql.NullString
+		var createdAt int64
+		if err := rows.Scan(&event.ID, &event.Action, &shortCode, &event.URL, &source, &entry, &event.Actor, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan blocklist event: %w", err)
+		}
+		event.ShortCode = shortCode.String
+		event.Source = source.String
+		event.Entry = entry.String
+		event.CreatedAt = time.Unix(createdAt, 0)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list blocklist events: %w", err)
+	}
+
+	return events, nil
+}
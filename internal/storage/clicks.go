@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+func (s *SQLStore) RecordClicks(clicks []models.ClickAnalytics) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	return s.withTx(func(c conn) error {
+		for _, click := range clicks {
+			// Skip clicks for links deleted since the redirect, and clicks
+			// already stored by an earlier replay of the same batch. The
+			// casts let PostgreSQL type the parameters of INSERT ... SELECT.
+			result, err := c.exec(`
+				INSERT INTO click_analytics (event_id, short_code, timestamp, user_agent, ip_address, referrer, reason)
+				SELECT CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS BIGINT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT)
+				WHERE EXISTS (SELECT 1 FROM link_mappings WHERE short_code = ?)
+				ON CONFLICT (event_id) DO NOTHING
+			`, click.EventID, click.ShortCode, click.Timestamp.Unix(), click.UserAgent, click.IPAddress, click.Referrer, stringOrNil(click.Reason), click.ShortCode)
+			if err != nil {
+				return fmt.Errorf("failed to record click analytics for code '%s': %w", click.ShortCode, err)
+			}
+			// Visits refused by an inactive link are kept for analytics
+			// but do not count as clicks
+			if n, _ := result.RowsAffected(); n == 0 || click.Counted || click.Reason != "" {
+				continue
+			}
+
+			// Update click count and last accessed
+			_, err = c.exec(`
+				UPDATE link_mappings
+				SET click_count = click_count + 1, last_accessed = ?
+				WHERE short_code = ?
+			`, click.Timestamp.Unix(), click.ShortCode)
+			if err != nil {
+				return fmt.Errorf("failed to update click count for code '%s': %w", click.ShortCode, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLStore) ClaimClick(shortCode string, at time.Time) (bool, error) {
+	// A single conditional UPDATE, so concurrent redirects on any number of
+	// instances cannot exceed the limit
+	result, err := s.conn().exec(`
+		UPDATE link_mappings
+		SET click_count = click_count + 1, last_accessed = ?
+		WHERE short_code = ? AND max_clicks IS NOT NULL AND click_count < max_clicks
+	`, at.Unix(), shortCode)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim click for code '%s': %w", shortCode, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim click for code '%s': %w", shortCode, err)
+	}
+	return n > 0, nil
+}
+
+func (s *SQLStore) RecentClicks(limit int, ownerID *int64) ([]models.ClickAnalytics, error) {
+	var whereClause string
+	var args []interface{}
+	if ownerID != nil {
+		whereClause = "WHERE short_code IN (SELECT short_code FROM link_mappings WHERE owner_id = ?)"
+		args = append(args, *ownerID)
+	}
+
+	rows, err := s.conn().query(fmt.Sprintf(`
+		SELECT id, short_code, timestamp, user_agent, ip_address, referrer, reason
+		FROM click_analytics %s
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, whereClause), append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent clicks: %w", err)
+	}
+	defer rows.Close()
+
+	var clicks []models.ClickAnalytics
+	for rows.Next() {
+		var click models.ClickAnalytics
+		var timestamp int64
+		var userAgent, ipAddress, referrer, reason sql.NullString
+
+		if err := rows.Scan(&click.ID, &click.ShortCode, &timestamp, &userAgent, &ipAddress, &referrer, &reason); err != nil {
+			return nil, fmt.Errorf("failed to scan click: %w", err)
+		}
+
+		click.Timestamp = time.Unix(timestamp, 0)
+		click.UserAgent = userAgent.String
+		click.IPAddress = ipAddress.String
+		click.Referrer = referrer.String
+		click.Reason = reason.String
+		clicks = append(clicks, click)
+	}
+
+	return clicks, rows.Err()
+}
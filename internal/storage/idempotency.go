@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+func (s *SQLStore) CreateIdempotencyKey(record *models.IdempotencyRecord) error {
+	_, err := s.conn().exec(`
+		INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, status_code, created_at, expires_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+	`, record.Scope, record.Key, record.RequestHash, record.CreatedAt.Unix(), record.ExpiresAt.Unix())
+	if err != nil {
+		if s.db.Dialect.IsUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) GetIdempotencyKey(scope, key string) (*models.IdempotencyRecord, error) {
+	record := models.IdempotencyRecord{Scope: scope, Key: key}
+	var contentType, location, body sql.NullString
+	var createdAt, expiresAt int64
+
+	err := s.conn().queryRow(`
+		SELECT request_hash, status_code, content_type, location, body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE scope = ? AND idempotency_key = ?
+	`, scope, key).Scan(&record.RequestHash, &record.StatusCode, &contentType, &location, &body, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+
+	record.ContentType = contentType.String
+	record.Location = location.String
+	record.Body = []byte(body.String)
+	record.CreatedAt = time.Unix(createdAt, 0)
+	record.ExpiresAt = time.Unix(expiresAt, 0)
+	return &record, nil
+}
+
+func (s *SQLStore) CompleteIdempotencyKey(record *models.IdempotencyRecord) error {
+	result, err := s.conn().exec(`
+		UPDATE idempotency_keys SET status_code = ?, content_type = ?, location = ?, body = ?
+		WHERE scope = ? AND idempotency_key = ?
+	`, record.StatusCode, stringOrNil(record.ContentType), stringOrNil(record.Location), string(record.Body), record.Scope, record.Key)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteIdempotencyKey(scope, key string) error {
+	if _, err := s.conn().exec(`DELETE FROM idempotency_keys WHERE scope = ? AND idempotency_key = ?`, scope, key); err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteExpiredIdempotencyKeys(now time.Time) error {
+	if _, err := s.conn().exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.Unix()); err != nil {
+		return fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	return nil
+}
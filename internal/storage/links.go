@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// linkColumns lists the link_mappings columns read by scanLink, in order.
+const linkColumns = `short_code, original_url, created_at, created_by, click_count, last_accessed, expires_at, max_clicks, fallback_url, owner_id, disabled_at, disabled_reason, disabled_by, deleted_at, deleted_by`
+
+func (s *SQLStore) CreateLink(link *models.LinkMapping) error {
+	return s.withTx(func(c conn) error {
+		_, err := c.exec(`
+			INSERT INTO link_mappings (`+insertLinkColumns+`)
+			VALUES (?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?)
+		`, insertLinkArgs(link)...)
+
+		if err != nil {
+			if s.db.Dialect.IsUniqueViolation(err) {
+				return ErrConflict
+			}
+			return fmt.Errorf("failed to store URL mapping: %w", err)
+		}
+
+		return recordLinkVersion(c, link.ShortCode, link.OriginalURL, link.CreatedBy, nil, link.CreatedAt)
+	})
+}
+
+func (s *SQLStore) CreateLinkBatch(fn func(batch LinkBatch) error) error {
+	return s.withTx(func(c conn) error {
+		return fn(linkBatch{c})
+	})
+}
+
+// linkBatch inserts links in a transaction. A failed statement aborts a
+// PostgreSQL transaction, so taken codes are skipped rather than raising a
+// unique violation.
+type linkBatch struct {
+	c conn
+}
+
+func (b linkBatch) CreateLink(link *models.LinkMapping) error {
+	result, err := b.c.exec(`
+		INSERT INTO link_mappings (`+insertLinkColumns+`)
+		VALUES (?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (short_code) DO NOTHING
+	`, insertLinkArgs(link)...)
+	if err != nil {
+		return fmt.Errorf("failed to store URL mapping: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return recordLinkVersion(b.c, link.ShortCode, link.OriginalURL, link.CreatedBy, nil, link.CreatedAt)
+}
+
+// insertLinkColumns are the columns set by insertLinkArgs, in order.
+const insertLinkColumns = `short_code, original_url, created_at, created_by, click_count, expires_at, max_clicks, fallback_url, owner_id, normalized_url, created_by_key`
+
+func insertLinkArgs(link *models.LinkMapping) []interface{} {
+	return []interface{}{link.ShortCode, link.OriginalURL, link.CreatedAt.Unix(), link.CreatedBy, unixOrNil(link.ExpiresAt), intOrNil(link.MaxClicks), stringOrNil(link.FallbackURL), int64OrNil(link.OwnerID), stringOrNil(link.NormalizedURL), int64OrNil(link.CreatedByKeyID)}
+}
+
+func (s *SQLStore) GetLink(shortCode string) (*models.LinkMapping, error) {
+	row := s.conn().queryRow(`SELECT `+linkColumns+` FROM link_mappings WHERE short_code = ?`, shortCode)
+
+	link, err := scanLink(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return link, nil
+}
+
+func (s *SQLStore) FindActiveLink(creator *models.Principal, normalizedURL string, now time.Time) (*models.LinkMapping, error) {
+	var condition string
+	var arg interface{}
+	switch {
+	case creator.UserID != 0:
+		condition, arg = `owner_id = ?`, creator.UserID
+	case creator.KeyID != 0:
+		condition, arg = `owner_id IS NULL AND created_by_key = ?`, creator.KeyID
+	default:
+		condition, arg = `owner_id IS NULL AND created_by_key IS NULL AND created_by = ?`, creator.Name
+	}
+
+	row := s.conn().queryRow(`
+		SELECT `+linkColumns+`
+		FROM link_mappings
+		WHERE `+condition+` AND normalized_url = ?
+		  AND disabled_at IS NULL AND deleted_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > ?)
+		  AND (max_clicks IS NULL OR click_count < max_clicks)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, arg, normalizedURL, now.Unix())
+
+	link, err := scanLink(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return link, nil
+}
+
+func (s *SQLStore) UpdateLink(shortCode string, update LinkUpdate) error {
+	var sets []string
+	var args []interface{}
+
+	if update.OriginalURL != nil {
+		sets = append(sets, "original_url = ?", "normalized_url = ?")
+		args = append(args, *update.OriginalURL, stringOrNil(update.NormalizedURL))
+	}
+	if update.ExpiresAt.Set {
+		sets = append(sets, "expires_at = ?")
+		args = append(args, unixOrNil(update.ExpiresAt.Value))
+	}
+	if update.MaxClicks.Set {
+		sets = append(sets, "max_clicks = ?")
+		args = append(args, intOrNil(update.MaxClicks.Value))
+	}
+	if update.FallbackURL.Set {
+		sets = append(sets, "fallback_url = ?")
+		args = append(args, update.FallbackURL.Value)
+	}
+
+	if len(sets) == 0 {
+		_, err := s.GetLink(shortCode)
+		return err
+	}
+
+	return s.withTx(func(c conn) error {
+		result, err := c.exec(`
+			UPDATE link_mappings SET `+strings.Join(sets, ", ")+` WHERE short_code = ?
+		`, append(args, shortCode)...)
+		if err != nil {
+			return fmt.Errorf("failed to update link: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		if update.OriginalURL == nil {
+			return nil
+		}
+		return recordLinkVersion(c, shortCode, *update.OriginalURL, update.ChangedBy, update.RestoredVersion, update.ChangedAt)
+	})
+}
+
+func (s *SQLStore) DeleteLink(shortCode, deletedBy string, at time.Time) error {
+	result, err := s.conn().exec(`
+		UPDATE link_mappings SET deleted_at = ?, deleted_by = ?
+		WHERE short_code = ? AND deleted_at IS NULL
+	`, at.Unix(), stringOrNil(deletedBy), shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to delete link: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) RestoreLink(shortCode string) error {
+	result, err := s.conn().exec(`
+		UPDATE link_mappings SET deleted_at = NULL, deleted_by = NULL
+		WHERE short_code = ? AND deleted_at IS NOT NULL
+	`, shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to restore link: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) DisableLinkManually(shortCode, disabledBy, reason string, at time.Time) error {
+	result, err := s.conn().exec(`
+		UPDATE link_mappings SET disabled_at = ?, disabled_reason = ?, disabled_by = ?
+		WHERE short_code = ? AND disabled_at IS NULL
+	`, at.Unix(), reason, disabledBy, shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to disable link: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) EnableLinkManually(shortCode string) error {
+	result, err := s.conn().exec(`
+		UPDATE link_mappings SET disabled_at = NULL, disabled_reason = NULL, disabled_by = NULL
+		WHERE short_code = ? AND disabled_by IS NOT NULL
+	`, shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to enable link: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) PurgeDeletedLinks(before, now time.Time) ([]string, error) {
+	var purged []string
+	err := s.withTx(func(c conn) error {
+		purged = nil
+		rows, err := c.query(`SELECT short_code FROM link_mappings WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to find deleted links: %w", err)
+		}
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan deleted link: %w", err)
+			}
+			purged = append(purged, code)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to find deleted links: %w", err)
+		}
+		if len(purged) == 0 {
+			return nil
+		}
+
+		// Clicks reference the link, so they are moved to archived_clicks
+		// before it goes. History is dropped along with the link.
+		const deleted = `SELECT short_code FROM link_mappings WHERE deleted_at IS NOT NULL AND deleted_at < ?`
+		_, err = c.exec(`
+			INSERT INTO archived_clicks (event_id, short_code, timestamp, user_agent, ip_address, referrer, reason, archived_at)
+			SELECT event_id, short_code, timestamp, user_agent, ip_address, referrer, reason, ?
+			FROM click_analytics WHERE short_code IN (`+deleted+`)
+		`, now.Unix(), before.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to archive click analytics: %w", err)
+		}
+		if _, err := c.exec(`DELETE FROM click_analytics WHERE short_code IN (`+deleted+`)`, before.Unix()); err != nil {
+			return fmt.Errorf("failed to archive click analytics: %w", err)
+		}
+		if _, err := c.exec(`DELETE FROM link_versions WHERE short_code IN (`+deleted+`)`, before.Unix()); err != nil {
+			return fmt.Errorf("failed to purge link history: %w", err)
+		}
+
+		if _, err := c.exec(`DELETE FROM link_mappings WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before.Unix()); err != nil {
+			return fmt.Errorf("failed to purge links: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *SQLStore) CountLinksByCodeLength() (map[int]int, error) {
+	rows, err := s.conn().query(`SELECT LENGTH(short_code), COUNT(*) FROM link_mappings GROUP BY LENGTH(short_code)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count links: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var length, count int
+		if err := rows.Scan(&length, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan link count: %w", err)
+		}
+		counts[length] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *SQLStore) ListLinks(filter LinkFilter) (*LinkPage, error) {
+	// Build WHERE clause for search. LOWER keeps matching case-insensitive
+	// on PostgreSQL, where LIKE is case-sensitive.
+	var conditions []string
+	var args []interface{}
+
+	if filter.Search != "" {
+		searchPattern := "%" + strings.ToLower(filter.Search) + "%"
+		conditions = append(conditions, "(LOWER(short_code) LIKE ? OR LOWER(original_url) LIKE ?)")
+		args = append(args, searchPattern, searchPattern)
+	}
+	if filter.OwnerID != nil {
+		conditions = append(conditions, "owner_id = ?")
+		args = append(args, *filter.OwnerID)
+	}
+	if filter.Deleted {
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	} else {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	var whereClause string
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	c := s.conn()
+
+	// Get total count first
+	var page LinkPage
+	err := c.queryRow(fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(SUM(click_count), 0)
+		FROM link_mappings %s
+	`, whereClause), args...).Scan(&page.TotalLinks, &page.TotalClicks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totals: %w", err)
+	}
+
+	// Get paginated links
+	offset := (filter.Page - 1) * filter.PageSize
+	rows, err := c.query(fmt.Sprintf(`
+		SELECT %s
+		FROM link_mappings %s
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`, linkColumns, whereClause), append(args, filter.PageSize, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch links: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		page.Links = append(page.Links, *link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch links: %w", err)
+	}
+
+	return &page, nil
+}
+
+func scanLink(row rowScanner) (*models.LinkMapping, error) {
+	var link models.LinkMapping
+	var createdAt int64
+	var createdBy, fallbackURL, disabledReason, disabledBy, deletedBy sql.NullString
+	var lastAccessed, expiresAt, maxClicks, ownerID, disabledAt, deletedAt sql.NullInt64
+
+	if err := row.Scan(&link.ShortCode, &link.OriginalURL, &createdAt, &createdBy, &link.ClickCount, &lastAccessed, &expiresAt, &maxClicks, &fallbackURL, &ownerID, &disabledAt, &disabledReason, &disabledBy, &deletedAt, &deletedBy); err != nil {
+		return nil, err
+	}
+
+	link.CreatedAt = time.Unix(createdAt, 0)
+	link.CreatedBy = createdBy.String
+	link.FallbackURL = fallbackURL.String
+	if lastAccessed.Valid {
+		t := time.Unix(lastAccessed.Int64, 0)
+		link.LastAccessed = &t
+	}
+	if expiresAt.Valid {
+		t := time.Unix(expiresAt.Int64, 0)
+		link.ExpiresAt = &t
+	}
+	if maxClicks.Valid {
+		n := int(maxClicks.Int64)
+		link.MaxClicks = &n
+	}
+	if ownerID.Valid {
+		link.OwnerID = &ownerID.Int64
+	}
+	if disabledAt.Valid {
+		t := time.Unix(disabledAt.Int64, 0)
+		link.DisabledAt = &t
+		link.DisabledReason = disabledReason.String
+		link.DisabledBy = disabledBy.String
+	}
+	if deletedAt.Valid {
+		t := time.Unix(deletedAt.Int64, 0)
+		link.DeletedAt = &t
+		link.DeletedBy = deletedBy.String
+	}
+
+	return &link, nil
+}
+
+// unixOrNil converts an optional time to a nullable unix timestamp.
+func unixOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+// stringOrNil stores an empty string as NULL.
+func stringOrNil(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// intOrNil converts an optional int to a nullable column value.
+func intOrNil(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+// int64OrNil converts an optional ID to a nullable column value.
+func int64OrNil(n *int64) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+)
+
+// SQLStore implements Store on top of a database.DB. Queries are written
+// once with ? placeholders and rebound for the connection's dialect, so the
+// same store serves both SQLite and PostgreSQL.
+type SQLStore struct {
+	db *database.DB
+}
+
+// NewSQLStore wraps an open database.
+func NewSQLStore(db *database.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// conn runs dialect-neutral queries against a pool or a transaction.
+type conn struct {
+	q       queryer
+	dialect database.Dialect
+}
+
+func (c conn) exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.q.Exec(c.dialect.Rebind(query), args...)
+}
+
+func (c conn) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.q.Query(c.dialect.Rebind(query), args...)
+}
+
+func (c conn) queryRow(query string, args ...interface{}) *sql.Row {
+	return c.q.QueryRow(c.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) conn() conn {
+	return conn{q: s.db.DB, dialect: s.db.Dialect}
+}
+
+// withTx runs fn inside a transaction, committing if it returns nil.
+func (s *SQLStore) withTx(fn func(c conn) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	if err := fn(conn{q: tx, dialect: s.db.Dialect}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/database"
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+func TestCreateLinkConflict(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		createTestLink(t, s, "taken", nil)
+
+		err := s.CreateLink(&models.LinkMapping{ShortCode: "taken", OriginalURL: "https://example.org", CreatedAt: time.Now()})
+		if err != ErrConflict {
+			t.Fatalf("CreateLink with a taken code = %v, want ErrConflict", err)
+		}
+	})
+}
+
+func TestRecordClicksSkipsReplays(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		createTestLink(t, s, "clicks", nil)
+
+		at := time.Unix(1_700_000_000, 0)
+		batch := []models.ClickAnalytics{
+			{EventID: "e1", ShortCode: "clicks", Timestamp: at, IPAddress: "192.0.2.1"},
+			{EventID: "e2", ShortCode: "clicks", Timestamp: at.Add(time.Second), IPAddress: "192.0.2.2"},
+			{EventID: "e3", ShortCode: "clicks", Timestamp: at.Add(2 * time.Second), Reason: models.LinkStateExpired},
+			{EventID: "e4", ShortCode: "missing", Timestamp: at},
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := s.RecordClicks(batch); err != nil {
+				t.Fatalf("RecordClicks (attempt %d): %v", i+1, err)
+			}
+		}
+
+		link, err := s.GetLink("clicks")
+		if err != nil {
+			t.Fatalf("GetLink: %v", err)
+		}
+		if link.ClickCount != 2 {
+			t.Errorf("click_count = %d, want 2", link.ClickCount)
+		}
+		if link.LastAccessed == nil || !link.LastAccessed.Equal(at.Add(time.Second)) {
+			t.Errorf("last_accessed = %v, want %v", link.LastAccessed, at.Add(time.Second))
+		}
+
+		stored := countRows(t, s, `SELECT COUNT(*) FROM click_analytics WHERE short_code = ?`, "clicks")
+		if stored != 3 {
+			t.Errorf("stored %d clicks, want 3", stored)
+		}
+		if n := countRows(t, s, `SELECT COUNT(*) FROM click_analytics WHERE short_code = ?`, "missing"); n != 0 {
+			t.Errorf("stored %d clicks for a missing link, want 0", n)
+		}
+	})
+}
+
+func TestRecordClicksCounted(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		maxClicks := 5
+		createTestLink(t, s, "counted", &maxClicks)
+
+		if ok, err := s.ClaimClick("counted", time.Now()); err != nil || !ok {
+			t.Fatalf("ClaimClick = %v, %v", ok, err)
+		}
+		err := s.RecordClicks([]models.ClickAnalytics{
+			{EventID: "c1", ShortCode: "counted", Timestamp: time.Now(), Counted: true},
+		})
+		if err != nil {
+			t.Fatalf("RecordClicks: %v", err)
+		}
+
+		link, err := s.GetLink("counted")
+		if err != nil {
+			t.Fatalf("GetLink: %v", err)
+		}
+		if link.ClickCount != 1 {
+			t.Errorf("click_count = %d, want 1", link.ClickCount)
+		}
+	})
+}
+
+func TestClaimClick(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		maxClicks := 2
+		createTestLink(t, s, "limited", &maxClicks)
+		createTestLink(t, s, "unlimited", nil)
+
+		for i, want := range []bool{true, true, false, false} {
+			ok, err := s.ClaimClick("limited", time.Now())
+			if err != nil {
+				t.Fatalf("ClaimClick %d: %v", i+1, err)
+			}
+			if ok != want {
+				t.Errorf("ClaimClick %d = %v, want %v", i+1, ok, want)
+			}
+		}
+
+		link, err := s.GetLink("limited")
+		if err != nil {
+			t.Fatalf("GetLink: %v", err)
+		}
+		if link.ClickCount != 2 {
+			t.Errorf("click_count = %d, want 2", link.ClickCount)
+		}
+
+		// Links without a limit are counted when their clicks are recorded
+		if ok, err := s.ClaimClick("unlimited", time.Now()); err != nil || ok {
+			t.Errorf("ClaimClick on a link without max_clicks = %v, %v; want false", ok, err)
+		}
+		if ok, err := s.ClaimClick("missing", time.Now()); err != nil || ok {
+			t.Errorf("ClaimClick on a missing link = %v, %v; want false", ok, err)
+		}
+	})
+}
+
+func TestFindActiveLinkByCreator(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		alice := &models.User{Username: "alice", Role: models.RoleCreator, CreatedAt: time.Now()}
+		if err := s.CreateUser(alice, "hash"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		apiKey := &models.APIKey{Name: "alice", Prefix: "ls_1", Scopes: []string{models.ScopeLinksWrite}, CreatedAt: time.Now()}
+		if err := s.CreateAPIKey(apiKey, "secret-hash"); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+		user, key := alice.ID, apiKey.ID
+
+		// A service key named like a user, and the user, each have a link
+		// to the same destination
+		created := time.Now().Add(-time.Minute)
+		for _, link := range []*models.LinkMapping{
+			{ShortCode: "byuser", CreatedBy: "alice", OwnerID: &user},
+			{ShortCode: "bykey", CreatedBy: "alice", CreatedByKeyID: &key},
+			{ShortCode: "bytoken", CreatedBy: "AUTH_TOKEN"},
+		} {
+			link.OriginalURL, link.NormalizedURL, link.CreatedAt = "https://example.com/", "https://example.com/", created
+			if err := s.CreateLink(link); err != nil {
+				t.Fatalf("CreateLink(%q): %v", link.ShortCode, err)
+			}
+		}
+
+		tests := []struct {
+			name    string
+			creator *models.Principal
+			want    string
+		}{
+			{"user", &models.Principal{UserID: user, Name: "alice"}, "byuser"},
+			{"user's key", &models.Principal{UserID: user, KeyID: key + 1, Name: "alice"}, "byuser"},
+			{"service key", &models.Principal{KeyID: key, Name: "alice"}, "bykey"},
+			{"other service key with the same name", &models.Principal{KeyID: key + 1, Name: "alice"}, ""},
+			{"legacy token", &models.Principal{Name: "AUTH_TOKEN"}, "bytoken"},
+			{"legacy token named like the user", &models.Principal{Name: "alice"}, ""},
+		}
+		for _, tt := range tests {
+			link, err := s.FindActiveLink(tt.creator, "https://example.com/", time.Now())
+			switch {
+			case tt.want == "" && err != ErrNotFound:
+				t.Errorf("%s: FindActiveLink = %v, %v; want ErrNotFound", tt.name, link, err)
+			case tt.want != "" && (err != nil || link.ShortCode != tt.want):
+				t.Errorf("%s: FindActiveLink = %v, %v; want %s", tt.name, link, err, tt.want)
+			}
+		}
+	})
+}
+
+func TestCountLinksByCodeLength(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		for _, code := range []string{"abc", "abd", "abcd"} {
+			createTestLink(t, s, code, nil)
+		}
+		if err := s.DeleteLink("abd", "test", time.Now()); err != nil {
+			t.Fatalf("DeleteLink: %v", err)
+		}
+
+		counts, err := s.CountLinksByCodeLength()
+		if err != nil {
+			t.Fatalf("CountLinksByCodeLength: %v", err)
+		}
+		if len(counts) != 2 || counts[3] != 2 || counts[4] != 1 {
+			t.Errorf("counts = %v, want map[3:2 4:1]", counts)
+		}
+	})
+}
+
+func TestPurgeDeletedLinksArchivesClicks(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		createTestLink(t, s, "old", nil)
+		createTestLink(t, s, "recent", nil)
+		createTestLink(t, s, "kept", nil)
+
+		at := time.Unix(1_700_000_000, 0)
+		err := s.RecordClicks([]models.ClickAnalytics{
+			{EventID: "p1", ShortCode: "old", Timestamp: at, IPAddress: "192.0.2.1"},
+			{EventID: "p2", ShortCode: "old", Timestamp: at, Reason: models.LinkStateDeleted},
+			{EventID: "p3", ShortCode: "kept", Timestamp: at},
+		})
+		if err != nil {
+			t.Fatalf("RecordClicks: %v", err)
+		}
+
+		now := time.Now()
+		if err := s.DeleteLink("old", "test", now.Add(-48*time.Hour)); err != nil {
+			t.Fatalf("DeleteLink: %v", err)
+		}
+		if err := s.DeleteLink("recent", "test", now); err != nil {
+			t.Fatalf("DeleteLink: %v", err)
+		}
+
+		purged, err := s.PurgeDeletedLinks(now.Add(-24*time.Hour), now)
+		if err != nil {
+			t.Fatalf("PurgeDeletedLinks: %v", err)
+		}
+		if len(purged) != 1 || purged[0] != "old" {
+			t.Fatalf("purged %v, want [old]", purged)
+		}
+
+		if _, err := s.GetLink("old"); err != ErrNotFound {
+			t.Errorf("GetLink on a purged link = %v, want ErrNotFound", err)
+		}
+		if _, err := s.GetLink("recent"); err != nil {
+			t.Errorf("GetLink on a recently deleted link: %v", err)
+		}
+		if n := countRows(t, s, `SELECT COUNT(*) FROM link_versions WHERE short_code = ?`, "old"); n != 0 {
+			t.Errorf("%d versions left for a purged link, want 0", n)
+		}
+		if n := countRows(t, s, `SELECT COUNT(*) FROM click_analytics WHERE short_code = ?`, "old"); n != 0 {
+			t.Errorf("%d clicks left in click_analytics for a purged link, want 0", n)
+		}
+		if n := countRows(t, s, `SELECT COUNT(*) FROM archived_clicks WHERE short_code = ? AND archived_at = ?`, "old", now.Unix()); n != 2 {
+			t.Errorf("archived %d clicks, want 2", n)
+		}
+		if n := countRows(t, s, `SELECT COUNT(*) FROM click_analytics WHERE short_code = ?`, "kept"); n != 1 {
+			t.Errorf("%d clicks on another link, want 1", n)
+		}
+
+		if purged, err := s.PurgeDeletedLinks(now.Add(-24*time.Hour), now); err != nil || len(purged) != 0 {
+			t.Errorf("second PurgeDeletedLinks = %v, %v; want nothing", purged, err)
+		}
+	})
+}
+
+func TestDisableLinkManually(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, s *SQLStore) {
+		createTestLink(t, s, "manual", nil)
+
+		if err := s.DisableLinkManually("manual", "alice", "disabled by alice", time.Now()); err != nil {
+			t.Fatalf("DisableLinkManually: %v", err)
+		}
+		if err := s.DisableLinkManually("manual", "alice", "disabled by alice", time.Now()); err != ErrNotFound {
+			t.Errorf("DisableLinkManually on a disabled link = %v, want ErrNotFound", err)
+		}
+		link, err := s.GetLink("manual")
+		if err != nil {
+			t.Fatalf("GetLink: %v", err)
+		}
+		if link.DisabledAt == nil || link.DisabledBy != "alice" || link.TakenDown() {
+			t.Fatalf("disabled by hand: disabled_at = %v, disabled_by = %q", link.DisabledAt, link.DisabledBy)
+		}
+		if n := countRows(t, s, `SELECT COUNT(*) FROM blocklist_events`); n != 0 {
+			t.Errorf("disabling by hand recorded %d blocklist events, want 0", n)
+		}
+
+		// A blocklist takedown is not undone by hand, and the recheck
+		// still considers links disabled by hand
+		if err := s.EnableLink(&models.BlocklistEvent{Action: models.BlocklistEnabled, ShortCode: "manual", CreatedAt: time.Now()}); err != ErrNotFound {
+			t.Errorf("EnableLink on a link disabled by hand = %v, want ErrNotFound", err)
+		}
+		links, err := s.ListLinksToRecheck("", 10)
+		if err != nil || len(links) != 1 {
+			t.Fatalf("ListLinksToRecheck = %d links, %v; want the link disabled by hand", len(links), err)
+		}
+		event := &models.BlocklistEvent{Action: models.BlocklistDisabled, ShortCode: "manual", URL: link.OriginalURL, Actor: "recheck", CreatedAt: time.Now()}
+		if err := s.DisableLink(event, "blocklisted"); err != nil {
+			t.Fatalf("DisableLink on a link disabled by hand: %v", err)
+		}
+		if link, _ := s.GetLink("manual"); link == nil || !link.TakenDown() {
+			t.Fatalf("after the takedown: %+v, want it taken down", link)
+		}
+		if err := s.EnableLinkManually("manual"); err != ErrNotFound {
+			t.Errorf("EnableLinkManually on a taken down link = %v, want ErrNotFound", err)
+		}
+		if links, _ := s.ListLinksToRecheck("", 10); len(links) != 0 {
+			t.Errorf("ListLinksToRecheck returned %d taken down links", len(links))
+		}
+
+		createTestLink(t, s, "again", nil)
+		if err := s.DisableLinkManually("again", "alice", "disabled by alice", time.Now()); err != nil {
+			t.Fatalf("DisableLinkManually: %v", err)
+		}
+		if err := s.EnableLinkManually("again"); err != nil {
+			t.Fatalf("EnableLinkManually: %v", err)
+		}
+		if link, _ := s.GetLink("again"); link == nil || link.DisabledAt != nil || link.DisabledBy != "" {
+			t.Errorf("after EnableLinkManually: %+v, want it enabled", link)
+		}
+	})
+}
+
+// forEachDialect runs fn against a migrated SQLite store and, when
+// DATABASE_URL is set, a migrated PostgreSQL store in a schema of its own
+// that is dropped afterwards. DATABASE_URL must be in URL form.
+func forEachDialect(t *testing.T, fn func(t *testing.T, s *SQLStore)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		fn(t, migrateTestDB(t, db))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		rawURL := os.Getenv("DATABASE_URL")
+		if rawURL == "" {
+			t.Skip("DATABASE_URL not set")
+		}
+
+		admin, err := database.Open(database.Config{Driver: database.Postgres, URL: rawURL})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer admin.Close()
+
+		schema := fmt.Sprintf("storage_test_%d", time.Now().UnixNano())
+		if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			t.Fatalf("create schema: %v", err)
+		}
+		defer admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatalf("parse DATABASE_URL: %v", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+
+		db, err := database.Open(database.Config{Driver: database.Postgres, URL: u.String()})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		fn(t, migrateTestDB(t, db))
+	})
+}
+
+func migrateTestDB(t *testing.T, db *database.DB) *SQLStore {
+	t.Helper()
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewSQLStore(db)
+}
+
+func createTestLink(t *testing.T, s *SQLStore, code string, maxClicks *int) {
+	t.Helper()
+	err := s.CreateLink(&models.LinkMapping{
+		ShortCode:   code,
+		OriginalURL: "https://example.com/" + code,
+		CreatedAt:   time.Now(),
+		CreatedBy:   "test",
+		MaxClicks:   maxClicks,
+	})
+	if err != nil {
+		t.Fatalf("CreateLink(%q): %v", code, err)
+	}
+}
+
+func countRows(t *testing.T, s *SQLStore, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := s.conn().queryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+// Package storage defines the persistence interfaces used by the services
+// and their SQL implementation for SQLite and PostgreSQL.
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a row with the same key already exists.
+	ErrConflict = errors.New("already exists")
+)
+
+// LinkFilter selects one page of links.
+type LinkFilter struct {
+	Page     int
+	PageSize int
+	Search   string
+	// OwnerID limits the page to one user's links when set.
+	OwnerID *int64
+	// Deleted selects deleted links instead of the others.
+	Deleted bool
+}
+
+// LinkPage is one page of links plus totals across the whole filter.
+type LinkPage struct {
+	Links       []models.LinkMapping
+	TotalLinks  int
+	TotalClicks int
+}
+
+// LinkUpdate lists the link fields to change; unset fields are left alone.
+type LinkUpdate struct {
+	OriginalURL *string
+	ExpiresAt   models.Optional[time.Time]
+	MaxClicks   models.Optional[int]
+	FallbackURL models.Optional[string]
+	// NormalizedURL is written along with OriginalURL.
+	NormalizedURL string
+	// A changed OriginalURL is added to the link's history as a version
+	// made by ChangedBy at ChangedAt, restoring RestoredVersion if set.
+	ChangedBy       string
+	ChangedAt       time.Time
+	RestoredVersion *int
+}
+
+// LinkBatch creates links inside the transaction of CreateLinkBatch.
+type LinkBatch interface {
+	// CreateLink inserts a link, returning ErrConflict if the short code
+	// is taken. Unlike other errors, a conflict leaves the batch usable.
+	CreateLink(link *models.LinkMapping) error
+}
+
+// LinkStore persists short link mappings.
+type LinkStore interface {
+	// CreateLink inserts a new link, returning ErrConflict if the short
+	// code is taken.
+	CreateLink(link *models.LinkMapping) error
+	GetLink(shortCode string) (*models.LinkMapping, error)
+	// FindActiveLink returns creator's newest link to normalizedURL that
+	// is neither deleted, disabled, expired nor out of clicks at now, or
+	// ErrNotFound. A user's links are those they own and an API key's
+	// without a user those it created; only the legacy AUTH_TOKEN is
+	// matched by name.
+	FindActiveLink(creator *models.Principal, normalizedURL string, now time.Time) (*models.LinkMapping, error)
+	UpdateLink(shortCode string, update LinkUpdate) error
+	// CreateLinkBatch runs fn with a LinkBatch whose links are committed
+	// together if fn returns nil, and not at all otherwise.
+	CreateLinkBatch(fn func(batch LinkBatch) error) error
+	// DeleteLink marks a link deleted by deletedBy at at, keeping its
+	// clicks and history. It returns ErrNotFound if the link does not
+	// exist or is already deleted.
+	DeleteLink(shortCode, deletedBy string, at time.Time) error
+	// RestoreLink undoes DeleteLink, returning ErrNotFound if the link
+	// does not exist or is not deleted.
+	RestoreLink(shortCode string) error
+	// DisableLinkManually disables a link on behalf of disabledBy, its
+	// owner or an admin, returning ErrNotFound if the link does not exist
+	// or is already disabled.
+	DisableLinkManually(shortCode, disabledBy, reason string, at time.Time) error
+	// EnableLinkManually undoes DisableLinkManually, returning ErrNotFound
+	// if the link does not exist or is not disabled by hand.
+	EnableLinkManually(shortCode string) error
+	// PurgeDeletedLinks permanently removes links deleted before before,
+	// along with their history, and returns their short codes. Their
+	// clicks are moved to archived_clicks, archived at now.
+	PurgeDeletedLinks(before, now time.Time) ([]string, error)
+	// CountLinksByCodeLength returns how many links, deleted ones
+	// included, have a short code of each length.
+	CountLinksByCodeLength() (map[int]int, error)
+	ListLinks(filter LinkFilter) (*LinkPage, error)
+	// ListLinkVersions returns a link's destination history, newest
+	// first. Links are created with version 1 and each UpdateLink that
+	// changes OriginalURL adds the next.
+	ListLinkVersions(shortCode string) ([]models.LinkVersion, error)
+	// GetLinkVersion returns one version of a link, or ErrNotFound.
+	GetLinkVersion(shortCode string, version int) (*models.LinkVersion, error)
+}
+
+// ClickStore persists click analytics.
+type ClickStore interface {
+	// RecordClicks stores a batch of clicks and bumps the per-link
+	// counters, except for clicks already Counted or refused with a
+	// Reason. Clicks whose EventID is already stored are skipped.
+	RecordClicks(clicks []models.ClickAnalytics) error
+	// ClaimClick counts a click against a link with max_clicks at redirect
+	// time. It returns false, counting nothing, once the limit is reached
+	// or if the link has no limit.
+	ClaimClick(shortCode string, at time.Time) (bool, error)
+	// RecentClicks returns the latest clicks, only on links owned by
+	// ownerID when it is set.
+	RecentClicks(limit int, ownerID *int64) ([]models.ClickAnalytics, error)
+}
+
+// APIKeyStore persists API keys. Keys are looked up by the hash of their
+// secret; the secret itself is never stored.
+type APIKeyStore interface {
+	// CreateAPIKey inserts a key and sets its ID.
+	CreateAPIKey(key *models.APIKey, secretHash string) error
+	GetAPIKeyByHash(secretHash string) (*models.APIKey, error)
+	ListAPIKeys() ([]models.APIKey, error)
+	RevokeAPIKey(id int64, at time.Time) error
+	TouchAPIKey(id int64, at time.Time) error
+}
+
+// UserStore persists dashboard accounts.
+type UserStore interface {
+	// CreateUser inserts a user and sets its ID, returning ErrConflict if
+	// the username is taken.
+	CreateUser(user *models.User, passwordHash string) error
+	// GetUserByUsername returns a user along with its password hash.
+	GetUserByUsername(username string) (*models.User, string, error)
+	ListUsers() ([]models.User, error)
+	SetUserPassword(id int64, passwordHash string) error
+	SetUserRole(id int64, role models.Role) error
+	CountUsersWithRole(role models.Role) (int, error)
+	// DeleteUser removes a user and its linked identities, signs out its
+	// sessions and revokes its API keys. Its links are kept without an
+	// owner.
+	DeleteUser(id int64) error
+}
+
+// SessionStore persists dashboard sessions. Sessions are looked up by the
+// hash of their token; the token itself is never stored.
+type SessionStore interface {
+	CreateSession(session *models.Session, tokenHash string) error
+	// GetSession returns the session with its user, or ErrNotFound. Expired
+	// sessions are still returned; callers check ExpiresAt.
+	GetSession(tokenHash string) (*models.Session, error)
+	DeleteSession(tokenHash string) error
+	DeleteUserSessions(userID int64) error
+	DeleteExpiredSessions(now time.Time) error
+}
+
+// IdentityStore links users to accounts at external identity providers,
+// keyed by the provider's issuer URL and its subject for the account.
+type IdentityStore interface {
+	// GetUserByIdentity returns the linked user, or ErrNotFound.
+	GetUserByIdentity(issuer, subject string) (*models.User, error)
+	// CreateIdentity links an account to a user, returning ErrConflict if
+	// the account is already linked.
+	CreateIdentity(identity *models.Identity, userID int64) error
+}
+
+// BlocklistStore disables links whose destinations are blocklisted and
+// keeps an audit trail of what the blocklist did.
+type BlocklistStore interface {
+	// ListLinksToRecheck returns up to limit links that are not taken
+	// down, that is enabled links and links disabled by hand, ordered by
+	// short code and starting after the given one.
+	ListLinksToRecheck(after string, limit int) ([]models.LinkMapping, error)
+	// DisableLink takes event.ShortCode down and records event, returning
+	// ErrNotFound if the link does not exist or is already taken down. A
+	// link disabled by hand is taken down as well.
+	DisableLink(event *models.BlocklistEvent, reason string) error
+	// EnableLink clears a takedown and records event, returning
+	// ErrNotFound if the link does not exist or is not taken down.
+	EnableLink(event *models.BlocklistEvent) error
+	RecordBlocklistEvent(event *models.BlocklistEvent) error
+	// ListBlocklistEvents returns the latest events, newest first.
+	ListBlocklistEvents(limit int) ([]models.BlocklistEvent, error)
+}
+
+// IdempotencyStore keeps the responses to requests made with an
+// Idempotency-Key, keyed by scope and key.
+type IdempotencyStore interface {
+	// CreateIdempotencyKey claims a key for a request that is about to be
+	// handled, returning ErrConflict if the key is already stored.
+	CreateIdempotencyKey(record *models.IdempotencyRecord) error
+	GetIdempotencyKey(scope, key string) (*models.IdempotencyRecord, error)
+	// CompleteIdempotencyKey stores the response of a claimed key.
+	CompleteIdempotencyKey(record *models.IdempotencyRecord) error
+	DeleteIdempotencyKey(scope, key string) error
+	DeleteExpiredIdempotencyKeys(now time.Time) error
+}
+
+// Store is the full persistence layer used by the application.
+type Store interface {
+	LinkStore
+	ClickStore
+	APIKeyStore
+	UserStore
+	SessionStore
+	IdentityStore
+	BlocklistStore
+	IdempotencyStore
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+func (s *SQLStore) CreateUser(user *models.User, passwordHash string) error {
+	err := s.conn().queryRow(`
+		INSERT INTO users (username, password_hash, role, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`, user.Username, passwordHash, user.Role, user.CreatedAt.Unix()).Scan(&user.ID)
+	if err != nil {
+		if s.db.Dialect.IsUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) GetUserByUsername(username string) (*models.User, string, error) {
+	var user models.User
+	var passwordHash string
+	var createdAt int64
+
+	err := s.conn().queryRow(`
+		SELECT id, username, password_hash, role, created_at FROM users WHERE username = ?
+	`, username).Scan(&user.ID, &user.Username, &passwordHash, &user.Role, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	user.CreatedAt = time.Unix(createdAt, 0)
+	return &user, passwordHash, nil
+}
+
+func (s *SQLStore) ListUsers() ([]models.User, error) {
+	rows, err := s.conn().query(`SELECT id, username, role, created_at FROM users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		var createdAt int64
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		user.CreatedAt = time.Unix(createdAt, 0)
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *SQLStore) SetUserPassword(id int64, passwordHash string) error {
+	result, err := s.conn().exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	if err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) SetUserRole(id int64, role models.Role) error {
+	result, err := s.conn().exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStore) CountUsersWithRole(role models.Role) (int, error) {
+	var count int
+	if err := s.conn().queryRow(`SELECT COUNT(*) FROM users WHERE role = ?`, role).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+func (s *SQLStore) DeleteUser(id int64) error {
+	return s.withTx(func(c conn) error {
+		// Sessions, identities, keys and links reference the user, so they
+		// have to go first
+		if _, err := c.exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+		if _, err := c.exec(`DELETE FROM user_identities WHERE user_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete identities: %w", err)
+		}
+		if _, err := c.exec(`
+			UPDATE api_keys SET user_id = NULL, revoked_at = COALESCE(revoked_at, ?) WHERE user_id = ?
+		`, time.Now().Unix(), id); err != nil {
+			return fmt.Errorf("failed to revoke API keys: %w", err)
+		}
+		if _, err := c.exec(`UPDATE link_mappings SET owner_id = NULL WHERE owner_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to release links: %w", err)
+		}
+
+		result, err := c.exec(`DELETE FROM users WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLStore) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	var user models.User
+	var createdAt int64
+
+	err := s.conn().queryRow(`
+		SELECT u.id, u.username, u.role, u.created_at
+		FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?
+	`, issuer, subject).Scan(&user.ID, &user.Username, &user.Role, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	user.CreatedAt = time.Unix(createdAt, 0)
+	return &user, nil
+}
+
+func (s *SQLStore) CreateIdentity(identity *models.Identity, userID int64) error {
+	_, err := s.conn().exec(`
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, identity.Issuer, identity.Subject, userID, identity.Email, time.Now().Unix())
+	if err != nil {
+		if s.db.Dialect.IsUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) CreateSession(session *models.Session, tokenHash string) error {
+	_, err := s.conn().exec(`
+		INSERT INTO sessions (token_hash, user_id, csrf_token, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, tokenHash, session.User.ID, session.CSRFToken, session.CreatedAt.Unix(), session.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) GetSession(tokenHash string) (*models.Session, error) {
+	var session models.Session
+	var userCreatedAt, createdAt, expiresAt int64
+
+	err := s.conn().queryRow(`
+		SELECT u.id, u.username, u.role, u.created_at, s.csrf_token, s.created_at, s.expires_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ?
+	`, tokenHash).Scan(&session.User.ID, &session.User.Username, &session.User.Role, &userCreatedAt,
+		&session.CSRFToken, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	session.User.CreatedAt = time.Unix(userCreatedAt, 0)
+	session.CreatedAt = time.Unix(createdAt, 0)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	return &session, nil
+}
+
+func (s *SQLStore) DeleteSession(tokenHash string) error {
+	if _, err := s.conn().exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteUserSessions(userID int64) error {
+	if _, err := s.conn().exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteExpiredSessions(now time.Time) error {
+	if _, err := s.conn().exec(`DELETE FROM sessions WHERE expires_at <= ?`, now.Unix()); err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return nil
+}
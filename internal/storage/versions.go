@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avantifellows/link-shortener/internal/models"
+)
+
+// linkVersionColumns lists the link_versions columns read by
+// scanLinkVersion, in order.
+const linkVersionColumns = `short_code, version, original_url, previous_url, changed_by, restored_version, created_at`
+
+func (s *SQLStore) ListLinkVersions(shortCode string) ([]models.LinkVersion, error) {
+	rows, err := s.conn().query(`
+		SELECT `+linkVersionColumns+`
+		FROM link_versions
+		WHERE short_code = ?
+		ORDER BY version DESC
+	`, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch link versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []models.LinkVersion{}
+	for rows.Next() {
+		version, err := scanLinkVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan link version: %w", err)
+		}
+		versions = append(versions, *version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch link versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+func (s *SQLStore) GetLinkVersion(shortCode string, version int) (*models.LinkVersion, error) {
+	row := s.conn().queryRow(`
+		SELECT `+linkVersionColumns+` FROM link_versions WHERE short_code = ? AND version = ?
+	`, shortCode, version)
+
+	v, err := scanLinkVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return v, nil
+}
+
+// recordLinkVersion adds the link's current destination to its history,
+// unless it is already the latest version. The caller must have updated
+// the link in the same transaction, which on PostgreSQL holds its row
+// lock and so keeps concurrent changes from taking the same number.
+func recordLinkVersion(c conn, shortCode, originalURL, changedBy string, restoredVersion *int, at time.Time) error {
+	var latest int
+	var previousURL sql.NullString
+	err := c.queryRow(`
+		SELECT version, original_url FROM link_versions
+		WHERE short_code = ?
+		ORDER BY version DESC
+		LIMIT 1
+	`, shortCode).Scan(&latest, &previousURL)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read link history: %w", err)
+	}
+	if previousURL.Valid && previousURL.String == originalURL {
+		return nil
+	}
+
+	_, err = c.exec(`
+		INSERT INTO link_versions (short_code, version, original_url, previous_url, changed_by, restored_version, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, shortCode, latest+1, originalURL, stringOrNil(previousURL.String), changedBy, intOrNil(restoredVersion), at.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record link version: %w", err)
+	}
+	return nil
+}
+
+func scanLinkVersion(row rowScanner) (*models.LinkVersion, error) {
+	var v models.LinkVersion
+	var previousURL sql.NullString
+	var restoredVersion sql.NullInt64
+	var createdAt int64
+
+	err := row.Scan(&v.ShortCode, &v.Version, &v.OriginalURL, &previousURL, &v.ChangedBy, &restoredVersion, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	v.PreviousURL = previousURL.String
+	if restoredVersion.Valid {
+		n := int(restoredVersion.Int64)
+		v.RestoredVersion = &n
+	}
+	v.CreatedAt = time.Unix(createdAt, 0)
+	return &v, nil
+}
@@ -0,0 +1,39 @@
+package urlpolicy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// defaultPorts are left out of normalized URLs.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Normalize returns the form of rawURL used to recognise the same
+// destination written differently: the scheme and host are lowercased,
+// a trailing dot on the host, the default port and an empty query or
+// fragment are dropped, and an empty path becomes "/". The path, query and
+// fragment are otherwise kept as they are, since servers may treat them
+// case-sensitively or depend on parameter order. URLs that do not parse
+// are returned unchanged.
+func Normalize(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.ForceQuery = false
+	return u.String()
+}
@@ -0,0 +1,248 @@
+// Package urlpolicy decides which destinations short links may point to.
+package urlpolicy
+
+import (
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Rules a destination can break, reported as Violation.Rule.
+const (
+	RuleInvalid          = "invalid_url"
+	RuleScheme           = "scheme_not_allowed"
+	RuleCredentials      = "credentials_in_url"
+	RuleDomainNotAllowed = "domain_not_allowed"
+	RuleDomainDenied     = "domain_denied"
+	RulePrivateAddress   = "private_address"
+	RuleRedirectLoop     = "redirect_loop"
+	RuleShortenerChain   = "shortener_chain"
+)
+
+// defaultShorteners are public URL shorteners. Pointing at one hides the
+// real destination and can loop back to us through a chain of redirects.
+var defaultShorteners = []string{
+	"bit.ly", "bitly.com", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "lnkd.in", "ow.ly",
+	"rb.gy", "rebrand.ly", "shorturl.at", "t.co", "t.ly", "tiny.cc", "tinyurl.com", "v.gd",
+}
+
+// Violation is a destination rejected by the policy.
+type Violation struct {
+	Rule   string
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// Config sets what the policy accepts. Domain lists match the domain
+// itself and every subdomain.
+type Config struct {
+	// Schemes lists the allowed URL schemes.
+	Schemes []string
+	// AllowedDomains, when not empty, is the only destinations allowed.
+	AllowedDomains []string
+	DeniedDomains  []string
+	// AllowPrivate permits loopback, private and link-local addresses.
+	AllowPrivate bool
+	// OwnHosts are the hosts short links are served from; links to them
+	// would redirect to ourselves.
+	OwnHosts []string
+	// Shorteners are other URL shorteners that links may not chain to.
+	Shorteners []string
+}
+
+// ConfigFromEnv reads the URL_* environment variables. The own host comes
+// from BASE_URL.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Schemes:        envList("URL_ALLOWED_SCHEMES", []string{"http", "https"}),
+		AllowedDomains: envList("URL_ALLOWED_DOMAINS", nil),
+		DeniedDomains:  envList("URL_DENIED_DOMAINS", nil),
+		Shorteners:     envList("URL_SHORTENER_DOMAINS", defaultShorteners),
+	}
+	cfg.AllowPrivate, _ = strconv.ParseBool(os.Getenv("URL_ALLOW_PRIVATE"))
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+		cfg.OwnHosts = []string{u.Hostname()}
+	}
+
+	return cfg
+}
+
+// envList reads a comma-separated list; "none" gives an empty list.
+func envList(name string, defaultValue []string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+	if value == "none" {
+		return nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Policy checks destinations against a Config. It is safe for concurrent
+// use.
+type Policy struct {
+	schemes        map[string]bool
+	allowedDomains []string
+	deniedDomains  []string
+	allowPrivate   bool
+	ownHosts       []string
+	shorteners     []string
+}
+
+func New(cfg Config) *Policy {
+	p := &Policy{
+		schemes:        make(map[string]bool),
+		allowedDomains: normalizeDomains(cfg.AllowedDomains),
+		deniedDomains:  normalizeDomains(cfg.DeniedDomains),
+		allowPrivate:   cfg.AllowPrivate,
+		ownHosts:       normalizeDomains(cfg.OwnHosts),
+		shorteners:     normalizeDomains(cfg.Shorteners),
+	}
+	for _, scheme := range cfg.Schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+	return p
+}
+
+// Check returns a *Violation if rawURL may not be used as a destination,
+// and nil otherwise.
+func (p *Policy) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return &Violation{RuleInvalid, "must be an absolute URL such as https://example.com/page"}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !p.schemes[scheme] {
+		return &Violation{RuleScheme, "the " + scheme + " scheme is not allowed"}
+	}
+
+	host := normalizeDomain(u.Hostname())
+	if host == "" {
+		return &Violation{RuleInvalid, "must be an absolute URL such as https://example.com/page"}
+	}
+	if u.User != nil {
+		// https://trusted.example@evil.example/ reads as the wrong site
+		return &Violation{RuleCredentials, "URLs with a username or password are not allowed"}
+	}
+
+	if matchDomain(host, p.ownHosts) {
+		return &Violation{RuleRedirectLoop, "links to " + host + " would redirect back to this service"}
+	}
+	if matchDomain(host, p.shorteners) {
+		return &Violation{RuleShortenerChain, host + " is a URL shortener; link to the final destination instead"}
+	}
+	if !p.allowPrivate && isPrivateHost(host) {
+		return &Violation{RulePrivateAddress, "loopback, private and local network addresses are not allowed"}
+	}
+	if matchDomain(host, p.deniedDomains) {
+		return &Violation{RuleDomainDenied, host + " is not allowed"}
+	}
+	if len(p.allowedDomains) > 0 && !matchDomain(host, p.allowedDomains) {
+		return &Violation{RuleDomainNotAllowed, host + " is not on the list of allowed domains"}
+	}
+
+	return nil
+}
+
+// isPrivateHost reports whether host names this machine or a local
+// network, including IPv4 addresses in the shorthand forms browsers
+// accept, such as 2130706433 or 0x7f.1 for 127.0.0.1.
+func isPrivateHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") ||
+		strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		var ok bool
+		if addr, ok = parseLooseIPv4(host); !ok {
+			return false
+		}
+	}
+	addr = addr.Unmap()
+
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), which is not
+// reachable from the internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// parseLooseIPv4 parses the inet_aton forms of an IPv4 address: one to four
+// dot-separated parts in decimal, octal (leading 0) or hex (leading 0x),
+// the last part filling the remaining bytes.
+func parseLooseIPv4(host string) (netip.Addr, bool) {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 0, 32)
+		if err != nil || part == "" || strings.ContainsAny(part, "+_") {
+			return netip.Addr{}, false
+		}
+		values[i] = v
+	}
+
+	var ip uint64
+	for i, v := range values[:len(values)-1] {
+		if v > 0xff {
+			return netip.Addr{}, false
+		}
+		ip |= v << (24 - 8*i)
+	}
+	last := values[len(values)-1]
+	if last >= 1<<(8*(5-len(values))) {
+		return netip.Addr{}, false
+	}
+	ip |= last
+
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), true
+}
+
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomains(domains []string) []string {
+	var result []string
+	for _, domain := range domains {
+		if domain = normalizeDomain(domain); domain != "" {
+			result = append(result, domain)
+		}
+	}
+	return result
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
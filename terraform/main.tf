@@ -18,13 +18,13 @@ resource "cloudflare_record" "app_dns" {
 resource "null_resource" "build_app" {
   triggers = {
     # Rebuild when source code changes
-    source_hash = filemd5("../cmd/server/main.go")
+    source_hash = sha1(join("", [for f in sort(fileset("..", "{cmd,internal}/**/*.go")) : filemd5("../${f}")]))
   }
 
   provisioner "local-exec" {
     command = <<-EOT
       cd ..
-      GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o terraform/link-shortener cmd/server/main.go
+      GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o terraform/link-shortener ./cmd/server
     EOT
   }
 }
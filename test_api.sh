@@ -26,24 +26,24 @@ else
 fi
 echo
 
-# Test 2: Dashboard without auth (should work - now public)
-echo "2. Testing dashboard without auth (should work)..."
+# Test 2: Dashboard without auth (should redirect to login)
+echo "2. Testing dashboard without auth (should redirect to login)..."
 response=$(curl -s -o /dev/null -w "%{http_code}" "$BASE_URL/")
-if [[ "$response" == "200" ]]; then
-    echo "✅ Dashboard accessible without auth"
+if [[ "$response" == "303" ]]; then
+    echo "✅ Dashboard redirects to login without auth"
 else
-    echo "❌ Dashboard failed without auth: HTTP $response"
+    echo "❌ Dashboard should redirect to login: HTTP $response"
     exit 1
 fi
 echo
 
-# Test 3: Analytics without auth (should work - now public)
-echo "3. Testing analytics without auth (should work)..."
+# Test 3: Analytics without auth (should fail)
+echo "3. Testing analytics without auth (should fail)..."
 response=$(curl -s -o /dev/null -w "%{http_code}" "$BASE_URL/analytics")
-if [[ "$response" == "200" ]]; then
-    echo "✅ Analytics accessible without auth"
+if [[ "$response" == "401" ]]; then
+    echo "✅ Analytics properly requires auth"
 else
-    echo "❌ Analytics failed without auth: HTTP $response"
+    echo "❌ Analytics should require auth: HTTP $response"
     exit 1
 fi
 echo
@@ -138,7 +138,7 @@ echo
 
 # Test 10: Test API endpoint with JSON accept header
 echo "10. Testing analytics with JSON accept header..."
-response=$(curl -s -H "Accept: application/json" "$BASE_URL/analytics")
+response=$(curl -s -H "Accept: application/json" -H "Authorization: Bearer $VALID_TOKEN" "$BASE_URL/analytics")
 if [[ "$response" == *"\"total_links\""* ]]; then
     echo "✅ JSON API response works"
 else
@@ -156,8 +156,8 @@ echo "- ✅ Link creation and redirect functionality works"
 echo "- ✅ JSON API responses work"
 echo
 echo "Authentication setup:"
-echo "- Dashboard: PUBLIC (no auth required)"
-echo "- Analytics: PUBLIC (no auth required)" 
-echo "- Link creation: PROTECTED (bearer token required)"
+echo "- Dashboard: PROTECTED (login required)"
+echo "- Analytics: PROTECTED (viewer role or above)"
+echo "- Link creation: PROTECTED (creator role or above)"
 echo "- Redirects: PUBLIC (no auth required)"
 echo "- Health check: PUBLIC (no auth required)"
\ No newline at end of file